@@ -0,0 +1,364 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// Answer modes
+const (
+	AnswerSingle = "SINGLE"
+	AnswerMulti  = "MULTI"
+	AnswerText   = "TEXT"
+)
+
+// AnswerRules struct
+type AnswerRules struct {
+	Mode          string `json:"mode"`
+	MaxSelections int    `json:"maxSelections"`
+	LockIn        bool   `json:"lockIn"`
+	Fuzzy         bool   `json:"fuzzy"`
+}
+
+// AnswerRuleError is returned when a team answer breaks the game's answer
+// rules.
+type AnswerRuleError struct {
+	Message string
+}
+
+func (e *AnswerRuleError) Error() string {
+	return e.Message
+}
+
+// defaultAnswerRules lets a team pick any number of answers and change them
+// until the question is over.
+func defaultAnswerRules() *AnswerRules {
+	return &AnswerRules{Mode: AnswerMulti}
+}
+
+// parseAnswerRules reads the rules stored with a game, falling back to the
+// defaults for games created before rules existed.
+func parseAnswerRules(value string) *AnswerRules {
+	rules := defaultAnswerRules()
+	if len(value) == 0 {
+		return rules
+	}
+	err := json.Unmarshal([]byte(value), rules)
+	if err != nil {
+		log.Error("Could not parse answer rules: ", err)
+		return defaultAnswerRules()
+	}
+	return rules
+}
+
+func (rules *AnswerRules) String() string {
+	b, _ := json.Marshal(rules)
+	return string(b)
+}
+
+func (rules *AnswerRules) validate() error {
+	if rules.Mode != AnswerSingle && rules.Mode != AnswerMulti && rules.Mode != AnswerText {
+		return fmt.Errorf("answering mode must be %s, %s or %s", AnswerSingle, AnswerMulti, AnswerText)
+	}
+	if rules.MaxSelections < 0 {
+		return fmt.Errorf("maxSelections can not be negative")
+	}
+	if rules.Mode == AnswerSingle && rules.MaxSelections > 1 {
+		return fmt.Errorf("maxSelections can not be more than 1 for %s answers", AnswerSingle)
+	}
+	return nil
+}
+
+// limit is the most answers a team may select or type per question, 0
+// meaning no limit.
+func (rules *AnswerRules) limit() int {
+	if rules.Mode == AnswerSingle {
+		return 1
+	}
+	return rules.MaxSelections
+}
+
+// gameAnswerRules reads the game's answer rules. Callers lock the game row
+// first so checks made against the rules can not race.
+func gameAnswerRules(tx *sql.Tx, gameID string) (*AnswerRules, error) {
+	var value string
+	err := tx.QueryRow(`
+		select coalesce(answering, '') from pbe.games where id = ?
+	`, gameID).Scan(&value)
+	if err != nil {
+		return nil, err
+	}
+	return parseAnswerRules(value), nil
+}
+
+// addTeamAnswer selects the answer for the team on the game's current
+// question and returns the team answer's ID.
+func addTeamAnswer(tx *sql.Tx, gameID, teamID, answerID string) (string, error) {
+	_, err := requireGameStatus(tx, gameID, GameStarted)
+	if err == nil {
+		err = requireGameTeam(tx, gameID, teamID)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	timer, err := getGameTimer(tx, gameID)
+	if err != nil {
+		return "", err
+	}
+	if timer.Expired && timer.RejectLate {
+		return "", &AnswerRuleError{"Time is up for the current question"}
+	}
+
+	err = checkTeamAnswer(tx, gameID, timer.GameQuestionID, teamID, answerID)
+	if err != nil {
+		return "", err
+	}
+
+	id, _ := UUID()
+	_, err = tx.Exec(`
+		insert into pbe.team_answers(id, game_id, game_question_id, team_id, answer_id, created, late)
+		values(?,?,?,?,?, NOW(),?)
+	`, id, gameID, timer.GameQuestionID, teamID, answerID, timer.Expired)
+	if isDuplicateKey(err) {
+		return "", &AnswerRuleError{"This answer has already been submitted"}
+	}
+	return id, err
+}
+
+// deleteTeamAnswer takes back the team's answer. Only answers to the current
+// question can be taken back.
+func deleteTeamAnswer(tx *sql.Tx, gameID, teamID, answerID string) error {
+	_, err := requireGameStatus(tx, gameID, GameStarted)
+	if err == nil {
+		err = requireGameTeam(tx, gameID, teamID)
+	}
+	if err != nil {
+		return err
+	}
+
+	timer, err := getGameTimer(tx, gameID)
+	if err != nil {
+		return err
+	}
+
+	err = checkTeamAnswerRemoval(tx, gameID, timer.GameQuestionID, teamID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		delete from pbe.team_answers
+		where game_id = ? and team_id = ? and answer_id = ? and game_question_id = ?
+	`, gameID, teamID, answerID, timer.GameQuestionID)
+	return err
+}
+
+// checkTeamAnswer makes sure the team may still select the answer: it belongs
+// to the game's current question, it has not been selected already, the
+// team has not locked in its answers to the question and the selection limit
+// has not been reached.
+func checkTeamAnswer(tx *sql.Tx, gameID, gameQuestionID, teamID, answerID string) error {
+	rules, err := gameAnswerRules(tx, gameID)
+	if err != nil {
+		return err
+	}
+
+	if rules.Mode == AnswerText {
+		return &AnswerRuleError{"This game takes typed responses"}
+	}
+
+	var questionID, currentQuestionID string
+	err = tx.QueryRow(`
+		select a.question_id, coalesce(gq.question_id, '')
+		from pbe.answers a
+		left join pbe.game_questions gq on gq.id = ? and gq.game_id = ?
+		where a.id = ?
+	`, gameQuestionID, gameID, answerID).Scan(&questionID, &currentQuestionID)
+	if err != nil {
+		return err
+	}
+	if len(currentQuestionID) == 0 {
+		return &AnswerRuleError{"The game has no current question"}
+	}
+	if questionID != currentQuestionID {
+		return &AnswerRuleError{"This answer is not for the current question"}
+	}
+
+	var selected, duplicates int
+	err = tx.QueryRow(`
+		select count(*), coalesce(sum(answer_id = ?), 0)
+		from pbe.team_answers
+		where game_id = ? and team_id = ? and game_question_id = ?
+	`, answerID, gameID, teamID, gameQuestionID).Scan(&selected, &duplicates)
+	if err != nil {
+		return err
+	}
+
+	if duplicates > 0 {
+		return &AnswerRuleError{"This answer has already been submitted"}
+	}
+	if rules.LockIn {
+		locked, err := isTeamLocked(tx, gameQuestionID, teamID)
+		if err != nil {
+			return err
+		}
+		if locked {
+			return &AnswerRuleError{"Answers to this question are locked in"}
+		}
+	}
+	if limit := rules.limit(); limit > 0 && selected >= limit {
+		if limit == 1 {
+			return &AnswerRuleError{"Only one answer can be selected for this question"}
+		}
+		return &AnswerRuleError{fmt.Sprintf("Only %d answers can be selected for this question", limit)}
+	}
+	return nil
+}
+
+// checkTeamAnswerRemoval stops teams from taking back answers to the question
+// once they are locked in: when the team locked them or, for a game with a
+// selection limit, once the team has reached it.
+func checkTeamAnswerRemoval(tx *sql.Tx, gameID, gameQuestionID, teamID string) error {
+	rules, err := gameAnswerRules(tx, gameID)
+	if err != nil {
+		return err
+	}
+	if !rules.LockIn {
+		return nil
+	}
+
+	locked, err := isTeamLocked(tx, gameQuestionID, teamID)
+	if err != nil {
+		return err
+	}
+	if !locked && rules.limit() > 0 {
+		var selected int
+		err = tx.QueryRow(`
+			select
+				(select count(*) from pbe.team_answers where game_question_id = ? and team_id = ?)
+				+ (select count(*) from pbe.team_responses where game_question_id = ? and team_id = ?)
+		`, gameQuestionID, teamID, gameQuestionID, teamID).Scan(&selected)
+		if err != nil {
+			return err
+		}
+		locked = selected >= rules.limit()
+	}
+	if locked {
+		return &AnswerRuleError{"Answers to this question are locked in"}
+	}
+	return nil
+}
+
+// isTeamLocked reports whether the team has locked in its answers to the game
+// question.
+func isTeamLocked(tx *sql.Tx, gameQuestionID, teamID string) (bool, error) {
+	var count int
+	err := tx.QueryRow(`
+		select count(*) from pbe.team_locks where game_question_id = ? and team_id = ?
+	`, gameQuestionID, teamID).Scan(&count)
+	return count > 0, err
+}
+
+// lockTeamAnswersController locks in the team's answers to the current
+// question, in games whose rules lock answers in. The team can not add or
+// take back answers to the question afterwards.
+func (s *Server) lockTeamAnswersController(c echo.Context) error {
+	gameID := c.Param("gameID")
+	teamID := c.Param("teamID")
+
+	allowed, err := s.answersForTeam(c, teamID)
+	if err != nil {
+		log.Error("Could not check team membership: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not check team membership: "+err.Error())
+	}
+	if !allowed {
+		return c.JSON(http.StatusForbidden, "You are not a member of this team")
+	}
+
+	err = s.store.LockTeamAnswers(c.Request().Context(), gameID, teamID, currentUser(c))
+	if err != nil {
+		log.Error("Could not lock team answers: ", err)
+		return c.JSON(gameErrorStatus(err), "Could not lock team answers: "+err.Error())
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// lockTeamAnswers locks in the team's answers to the game's current question.
+// Locking twice is not an error.
+func lockTeamAnswers(tx *sql.Tx, gameID, teamID, userID string) error {
+	_, err := requireGameStatus(tx, gameID, GameStarted)
+	if err == nil {
+		err = requireGameTeam(tx, gameID, teamID)
+	}
+	if err == nil {
+		err = checkTeamLock(tx, gameID)
+	}
+	if err != nil {
+		return err
+	}
+
+	timer, err := getGameTimer(tx, gameID)
+	if err != nil {
+		return err
+	}
+	if len(timer.GameQuestionID) == 0 {
+		return &AnswerRuleError{"The game has no current question"}
+	}
+
+	_, err = tx.Exec(`
+		insert ignore into pbe.team_locks(game_question_id, team_id, user_id, created)
+		values(?,?,?,NOW())
+	`, timer.GameQuestionID, teamID, userID)
+	return err
+}
+
+func checkTeamLock(tx *sql.Tx, gameID string) error {
+	rules, err := gameAnswerRules(tx, gameID)
+	if err != nil {
+		return err
+	}
+	if !rules.LockIn {
+		return &AnswerRuleError{"This game does not lock in answers"}
+	}
+	return nil
+}
+
+// isDuplicateKey reports whether err is MySQL rejecting a row that breaks a
+// unique key.
+func isDuplicateKey(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062
+}
+
+func (s *Server) updateAnsweringController(c echo.Context) error {
+	gameID := c.Param("gameID")
+	rules := defaultAnswerRules()
+	err := c.Bind(rules)
+	if err != nil {
+		log.Error("Could not parse answer rules: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse answer rules: "+err.Error())
+	}
+	err = rules.validate()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	err = s.store.UpdateAnswering(c.Request().Context(), gameID, rules)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, "Game not found: "+gameID)
+	}
+	if err != nil {
+		log.Error("Could not update answer rules: ", gameID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not update answer rules: "+gameID+" : "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, rules)
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// Appeal statuses
+const (
+	AppealOpen     = "OPEN"
+	AppealAccepted = "ACCEPTED"
+	AppealRejected = "REJECTED"
+)
+
+// Appeal struct
+type Appeal struct {
+	ID           string         `json:"id"`
+	GameID       string         `json:"gameId"`
+	TeamID       string         `json:"teamId"`
+	TeamName     string         `json:"teamName"`
+	TeamAnswerID string         `json:"teamAnswerId"`
+	QuestionID   string         `json:"questionId"`
+	AnswerID     string         `json:"answerId"`
+	Answer       string         `json:"answer"`
+	Ruling       bool           `json:"ruling"`
+	Reason       string         `json:"reason"`
+	Status       string         `json:"status"`
+	FiledBy      string         `json:"filedBy"`
+	Created      time.Time      `json:"created"`
+	Events       []*AppealEvent `json:"events"`
+}
+
+// AppealEvent struct
+type AppealEvent struct {
+	ID      string    `json:"id"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	UserID  string    `json:"userId"`
+	Note    string    `json:"note"`
+	Created time.Time `json:"created"`
+}
+
+// AppealDecision struct
+type AppealDecision struct {
+	Note string `json:"note"`
+}
+
+// appealColumns selects appeals with the answer they are about. The ruling is
+// what the answer is scored as: its own status unless an accepted appeal
+// overturned it.
+const appealColumns = `
+	select ap.id, ap.game_id, ap.team_id, t.name, ap.team_answer_id, a.question_id, a.id, a.answer,
+		coalesce(ta.ruling, a.status) = 1, ap.reason, ap.status, coalesce(ap.filed_by, ''), ap.created
+	from pbe.appeals ap
+	inner join pbe.teams t on t.id = ap.team_id
+	inner join pbe.team_answers ta on ta.id = ap.team_answer_id
+	inner join pbe.answers a on a.id = ta.answer_id
+`
+
+// addAppealController files an appeal against the ruling on one of the team's
+// answers. A team answer can only have one open appeal at a time.
+func (s *Server) addAppealController(c echo.Context) error {
+	gameID := c.Param("gameID")
+	teamID := c.Param("teamID")
+	answerID := c.Param("answerID")
+	appeal := &Appeal{}
+	err := c.Bind(&appeal)
+	if err != nil {
+		log.Error("Could not parse appeal: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse appeal: "+err.Error())
+	}
+	if len(strings.TrimSpace(appeal.Reason)) == 0 {
+		return c.JSON(http.StatusBadRequest, "reason is required")
+	}
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	tx, err := conn.Begin()
+	if err != nil {
+		log.Error("Could not start database transaction: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not start database transaction: "+err.Error())
+	}
+
+	_, err = requireGameStatus(tx, gameID, GameStarted, GamePaused, GameFinished)
+	if err == nil {
+		err = requireGameTeam(tx, gameID, teamID)
+	}
+	if err != nil {
+		log.Error("Could not add appeal: ", err)
+		tx.Rollback()
+		return c.JSON(gameErrorStatus(err), "Could not add appeal: "+err.Error())
+	}
+
+	allowed, err := canAnswerForTeam(c, tx, teamID)
+	if err != nil {
+		log.Error("Could not check team membership: ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not check team membership: "+err.Error())
+	}
+	if !allowed {
+		tx.Rollback()
+		return c.JSON(http.StatusForbidden, "You are not a member of this team")
+	}
+
+	var teamAnswerID string
+	err = tx.QueryRow(`
+		select id from pbe.team_answers where game_id = ? and team_id = ? and answer_id = ? for update
+	`, gameID, teamID, answerID).Scan(&teamAnswerID)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return c.JSON(http.StatusNotFound, "The team did not give this answer")
+	}
+	if err != nil {
+		log.Error("Could not get team answer: ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not get team answer: "+err.Error())
+	}
+
+	var open int
+	err = tx.QueryRow(`
+		select count(*) from pbe.appeals where team_answer_id = ? and status = 'OPEN'
+	`, teamAnswerID).Scan(&open)
+	if err != nil {
+		log.Error("Could not get appeals: ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not get appeals: "+err.Error())
+	}
+	if open > 0 {
+		tx.Rollback()
+		return c.JSON(http.StatusConflict, "This answer already has an open appeal")
+	}
+
+	appeal.ID, _ = UUID()
+	_, err = tx.Exec(`
+		insert into pbe.appeals(id, game_id, team_id, team_answer_id, reason, status, filed_by, created)
+		values(?,?,?,?,?,'OPEN',?,NOW())
+	`, appeal.ID, gameID, teamID, teamAnswerID, appeal.Reason, currentUser(c))
+	if err != nil {
+		log.Error("Could not add appeal: ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not add appeal: "+err.Error())
+	}
+
+	err = addAppealEvent(tx, appeal.ID, "", AppealOpen, currentUser(c), appeal.Reason)
+	if err != nil {
+		log.Error("Could not add appeal event: ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not add appeal event: "+err.Error())
+	}
+
+	tx.Commit()
+
+	appeal, err = getAppeal(conn, gameID, appeal.ID)
+	if err != nil {
+		log.Error("Could not get appeal: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get appeal: "+err.Error())
+	}
+
+	s.bus.Publish(&Event{Type: MsgAppealFiled, GameID: gameID, ModeratorsOnly: true, Payload: appeal})
+	return c.JSON(http.StatusOK, appeal)
+}
+
+// getAppealsController lists the game's appeals, optionally only those with
+// the given status.
+func (s *Server) getAppealsController(c echo.Context) error {
+	return s.appealsResponse(c, "")
+}
+
+// getTeamAppealsController lists the appeals filed by one team.
+func (s *Server) getTeamAppealsController(c echo.Context) error {
+	gameID := c.Param("gameID")
+	teamID := c.Param("teamID")
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	err := requireGameTeam(conn, gameID, teamID)
+	if err != nil {
+		return c.JSON(gameErrorStatus(err), "Could not get appeals: "+err.Error())
+	}
+
+	allowed, err := canAnswerForTeam(c, conn, teamID)
+	if err != nil {
+		log.Error("Could not check team membership: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not check team membership: "+err.Error())
+	}
+	if !allowed {
+		return c.JSON(http.StatusForbidden, "You are not a member of this team")
+	}
+
+	return s.appealsResponse(c, teamID)
+}
+
+func (s *Server) appealsResponse(c echo.Context, teamID string) error {
+	gameID := c.Param("gameID")
+	status := strings.ToUpper(c.QueryParam("status"))
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	rows, err := conn.Query(appealColumns+`
+		where ap.game_id = ? and (? = '' or ap.team_id = ?) and (? = '' or ap.status = ?)
+		order by ap.seq
+	`, gameID, teamID, teamID, status, status)
+	if err != nil {
+		log.Error("Could not get appeals: ", gameID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get appeals: "+gameID+" : "+err.Error())
+	}
+	defer rows.Close()
+
+	appeals := []*Appeal{}
+	for rows.Next() {
+		appeal, err := scanAppeal(rows)
+		if err != nil {
+			log.Error("Could not get appeal: ", err)
+			return c.JSON(http.StatusInternalServerError, "Could not get appeal: "+err.Error())
+		}
+		appeals = append(appeals, appeal)
+	}
+
+	return c.JSON(http.StatusOK, appeals)
+}
+
+func (s *Server) getAppealController(c echo.Context) error {
+	gameID := c.Param("gameID")
+	appealID := c.Param("appealID")
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	appeal, err := getAppeal(conn, gameID, appealID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, "Appeal not found: "+appealID)
+	}
+	if err != nil {
+		log.Error("Could not get appeal: ", appealID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get appeal: "+appealID+" : "+err.Error())
+	}
+
+	allowed, err := canAnswerForTeam(c, conn, appeal.TeamID)
+	if err != nil {
+		log.Error("Could not check team membership: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not check team membership: "+err.Error())
+	}
+	if !allowed {
+		return c.JSON(http.StatusForbidden, "You are not a member of this team")
+	}
+
+	return c.JSON(http.StatusOK, appeal)
+}
+
+// acceptAppealController overturns the ruling on the appealed answer, so a
+// wrong answer scores as correct and the other way round.
+func (s *Server) acceptAppealController(c echo.Context) error {
+	return s.decideAppealController(c, AppealAccepted)
+}
+
+func (s *Server) rejectAppealController(c echo.Context) error {
+	return s.decideAppealController(c, AppealRejected)
+}
+
+func (s *Server) decideAppealController(c echo.Context, to string) error {
+	gameID := c.Param("gameID")
+	appealID := c.Param("appealID")
+	decision := &AppealDecision{}
+	err := c.Bind(decision)
+	if err != nil {
+		log.Error("Could not parse decision: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse decision: "+err.Error())
+	}
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	tx, err := conn.Begin()
+	if err != nil {
+		log.Error("Could not start database transaction: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not start database transaction: "+err.Error())
+	}
+
+	var status, teamAnswerID string
+	err = tx.QueryRow(`
+		select status, team_answer_id from pbe.appeals where id = ? and game_id = ? for update
+	`, appealID, gameID).Scan(&status, &teamAnswerID)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return c.JSON(http.StatusNotFound, "Appeal not found: "+appealID)
+	}
+	if err != nil {
+		log.Error("Could not get appeal: ", appealID, " : ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not get appeal: "+appealID+" : "+err.Error())
+	}
+	if status != AppealOpen {
+		tx.Rollback()
+		return c.JSON(http.StatusConflict, "Appeal is already "+status)
+	}
+
+	_, err = tx.Exec(`
+		update pbe.appeals set status = ? where id = ?
+	`, to, appealID)
+	if err != nil {
+		log.Error("Could not update appeal: ", appealID, " : ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not update appeal: "+appealID+" : "+err.Error())
+	}
+
+	if to == AppealAccepted {
+		_, err = tx.Exec(`
+			update pbe.team_answers ta
+			inner join pbe.answers a on a.id = ta.answer_id
+			set ta.ruling = coalesce(ta.ruling, a.status) ^ 1
+			where ta.id = ?
+		`, teamAnswerID)
+		if err != nil {
+			log.Error("Could not overturn ruling: ", teamAnswerID, " : ", err)
+			tx.Rollback()
+			return c.JSON(http.StatusInternalServerError, "Could not overturn ruling: "+teamAnswerID+" : "+err.Error())
+		}
+	}
+
+	err = addAppealEvent(tx, appealID, status, to, currentUser(c), decision.Note)
+	if err != nil {
+		log.Error("Could not add appeal event: ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not add appeal event: "+err.Error())
+	}
+
+	tx.Commit()
+
+	if to == AppealAccepted {
+		s.publishScoreboard(gameID)
+	}
+
+	appeal, err := getAppeal(conn, gameID, appealID)
+	if err != nil {
+		log.Error("Could not get appeal: ", appealID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get appeal: "+appealID+" : "+err.Error())
+	}
+	return c.JSON(http.StatusOK, appeal)
+}
+
+func addAppealEvent(tx *sql.Tx, appealID, from, to, userID, note string) error {
+	id, _ := UUID()
+	_, err := tx.Exec(`
+		insert into pbe.appeal_events(id, appeal_id, from_status, to_status, user_id, note, created)
+		values(?,?,?,?,?,?,NOW())
+	`, id, appealID, from, to, userID, note)
+	return err
+}
+
+// getAppeal loads the appeal with its history, oldest event first.
+func getAppeal(conn *Conn, gameID, appealID string) (*Appeal, error) {
+	appeal, err := scanAppeal(conn.QueryRow(appealColumns+`
+		where ap.id = ? and ap.game_id = ?
+	`, appealID, gameID))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(`
+		select id, from_status, to_status, coalesce(user_id, ''), coalesce(note, ''), created
+		from pbe.appeal_events
+		where appeal_id = ?
+		order by seq
+	`, appealID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var created string
+		event := &AppealEvent{}
+		err = rows.Scan(&event.ID, &event.From, &event.To, &event.UserID, &event.Note, &created)
+		if err != nil {
+			return nil, err
+		}
+		event.Created, _ = time.Parse("2006-01-02 15:04:05", created)
+		appeal.Events = append(appeal.Events, event)
+	}
+	return appeal, rows.Err()
+}
+
+func scanAppeal(row rowScanner) (*Appeal, error) {
+	var created string
+	appeal := &Appeal{Events: []*AppealEvent{}}
+	err := row.Scan(&appeal.ID, &appeal.GameID, &appeal.TeamID, &appeal.TeamName, &appeal.TeamAnswerID,
+		&appeal.QuestionID, &appeal.AnswerID, &appeal.Answer, &appeal.Ruling, &appeal.Reason,
+		&appeal.Status, &appeal.FiledBy, &created)
+	if err != nil {
+		return nil, err
+	}
+	appeal.Created, _ = time.Parse("2006-01-02 15:04:05", created)
+	return appeal, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/spf13/viper"
+)
+
+// openBackplane opens the backplane named by events.backplane. "local", the
+// default, only reaches this instance. "mysql" shares events through the
+// database, so several instances behind a load balancer all reach their own
+// sessions; events.pollInterval sets how often each instance checks for new
+// ones, events.grace how long it keeps looking for events that commit out of
+// order and events.retention how long they are kept.
+func openBackplane(db *DB) (Backplane, error) {
+	viper.SetDefault("events.backplane", "local")
+	viper.SetDefault("events.pollInterval", "250ms")
+	viper.SetDefault("events.grace", "5s")
+	viper.SetDefault("events.retention", "1m")
+
+	switch backplane := viper.GetString("events.backplane"); backplane {
+	case "local":
+		return newEventBus(), nil
+	case "mysql":
+		if db == nil {
+			return nil, fmt.Errorf("The mysql backplane needs the mysql database driver")
+		}
+		retention := viper.GetDuration("events.retention")
+		grace := viper.GetDuration("events.grace")
+		if retention < 2*grace {
+			return nil, fmt.Errorf("events.retention must be at least twice events.grace")
+		}
+		backplane, err := newSharedBackplane(&mysqlBroadcasts{db: db}, grace)
+		if err != nil {
+			return nil, err
+		}
+		go backplane.poll(viper.GetDuration("events.pollInterval"), retention)
+		return backplane, nil
+	default:
+		return nil, fmt.Errorf("Unknown events backplane: %s", backplane)
+	}
+}
+
+// broadcast is one shared event as stored.
+type broadcast struct {
+	id       int64
+	instance string
+	event    []byte
+}
+
+// broadcastStore keeps the events shared between instances. Ids increase in
+// the order events are published, but an event can become visible after ones
+// with higher ids when its insert commits late.
+type broadcastStore interface {
+	// addBroadcast stores an event published by the instance.
+	addBroadcast(instance string, event []byte) error
+	// broadcasts returns the events with an id above afterID, and every
+	// event stored in the last grace period whatever its id, by id.
+	broadcasts(afterID int64, grace time.Duration) ([]*broadcast, error)
+	// deleteBroadcasts deletes the events stored before retention.
+	deleteBroadcasts(retention time.Duration) error
+}
+
+// sharedBackplane shares events between instances through a broadcastStore.
+// Events go to this instance's subscribers straight away, and every instance
+// polls the store for the events the others published.
+type sharedBackplane struct {
+	bus        *EventBus
+	broadcasts broadcastStore
+	instance   string
+	grace      time.Duration
+	lastID     int64
+	// seen holds the ids still inside the grace period that have already
+	// been delivered.
+	seen map[int64]bool
+}
+
+// sharedEvent is how an Event is stored in pbe.broadcasts.
+type sharedEvent struct {
+	Type           string          `json:"type"`
+	GameID         string          `json:"gameId"`
+	TeamID         string          `json:"teamId"`
+	ModeratorsOnly bool            `json:"moderatorsOnly"`
+	PlayersOnly    bool            `json:"playersOnly"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+func newSharedBackplane(broadcasts broadcastStore, grace time.Duration) (*sharedBackplane, error) {
+	backplane := &sharedBackplane{
+		bus:        newEventBus(),
+		broadcasts: broadcasts,
+		grace:      grace,
+		seen:       map[int64]bool{},
+	}
+	backplane.instance, _ = UUID()
+
+	// Only events published from now on are delivered.
+	_, err := backplane.unseen()
+	if err != nil {
+		return nil, err
+	}
+	return backplane, nil
+}
+
+func (backplane *sharedBackplane) Subscribe(fn func(event *Event)) {
+	backplane.bus.Subscribe(fn)
+}
+
+func (backplane *sharedBackplane) Publish(event *Event) {
+	backplane.bus.Publish(event)
+	if event.Local {
+		return
+	}
+
+	payload, err := json.Marshal(event.Payload)
+	if err != nil {
+		log.Error("Could not share ", event.Type, ": ", err)
+		return
+	}
+	data, _ := json.Marshal(&sharedEvent{
+		Type:           event.Type,
+		GameID:         event.GameID,
+		TeamID:         event.TeamID,
+		ModeratorsOnly: event.ModeratorsOnly,
+		PlayersOnly:    event.PlayersOnly,
+		Payload:        payload,
+	})
+
+	err = backplane.broadcasts.addBroadcast(backplane.instance, data)
+	if err != nil {
+		log.Error("Could not share ", event.Type, ": ", err)
+	}
+}
+
+// poll delivers the other instances' events once per interval and deletes
+// events older than retention once a minute.
+func (backplane *sharedBackplane) poll(interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	cleaned := time.Now()
+	for range ticker.C {
+		err := backplane.receive()
+		if err != nil {
+			log.Error("Could not get shared events: ", err)
+		}
+
+		if time.Since(cleaned) < time.Minute {
+			continue
+		}
+		cleaned = time.Now()
+		err = backplane.broadcasts.deleteBroadcasts(retention)
+		if err != nil {
+			log.Error("Could not delete old shared events: ", err)
+		}
+	}
+}
+
+// receive delivers the events the other instances published since the last
+// poll, including ones that committed after events with higher ids.
+func (backplane *sharedBackplane) receive() error {
+	broadcasts, err := backplane.unseen()
+	if err != nil {
+		return err
+	}
+
+	for _, b := range broadcasts {
+		if b.instance == backplane.instance {
+			continue
+		}
+		event, err := decodeSharedEvent(b.event)
+		if err != nil {
+			log.Error("Could not read shared event: ", b.id, " : ", err)
+			continue
+		}
+		backplane.bus.Publish(event)
+	}
+	return nil
+}
+
+// unseen returns the stored events that have not been delivered yet. Besides
+// the events after the newest one delivered, it reads every event from the
+// grace period again, so one whose insert committed late is still found. Ids
+// drop out of seen once they are too old to be read again.
+func (backplane *sharedBackplane) unseen() ([]*broadcast, error) {
+	broadcasts, err := backplane.broadcasts.broadcasts(backplane.lastID, backplane.grace)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[int64]bool{}
+	unseen := []*broadcast{}
+	for _, b := range broadcasts {
+		seen[b.id] = true
+		if b.id > backplane.lastID {
+			backplane.lastID = b.id
+		}
+		if !backplane.seen[b.id] {
+			unseen = append(unseen, b)
+		}
+	}
+	backplane.seen = seen
+	return unseen, nil
+}
+
+// mysqlBroadcasts keeps shared events in pbe.broadcasts. created is set by the
+// database, so every instance measures the grace period by the same clock.
+type mysqlBroadcasts struct {
+	db *DB
+}
+
+func (store *mysqlBroadcasts) addBroadcast(instance string, event []byte) error {
+	_, err := store.db.WithContext(context.Background()).Exec(`
+		insert into pbe.broadcasts(instance, event, created)
+		values(?,?,NOW())
+	`, instance, event)
+	return err
+}
+
+func (store *mysqlBroadcasts) broadcasts(afterID int64, grace time.Duration) ([]*broadcast, error) {
+	rows, err := store.db.WithContext(context.Background()).Query(`
+		select id, instance, event
+		from pbe.broadcasts
+		where id > ? or created >= date_sub(NOW(), interval ? second)
+		order by id
+	`, afterID, int(math.Ceil(grace.Seconds())))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	broadcasts := []*broadcast{}
+	for rows.Next() {
+		b := &broadcast{}
+		err = rows.Scan(&b.id, &b.instance, &b.event)
+		if err != nil {
+			return nil, err
+		}
+		broadcasts = append(broadcasts, b)
+	}
+	return broadcasts, rows.Err()
+}
+
+func (store *mysqlBroadcasts) deleteBroadcasts(retention time.Duration) error {
+	_, err := store.db.WithContext(context.Background()).Exec(`
+		delete from pbe.broadcasts where created < date_sub(NOW(), interval ? second)
+	`, int(retention.Seconds()))
+	return err
+}
+
+func decodeSharedEvent(data []byte) (*Event, error) {
+	shared := &sharedEvent{}
+	err := json.Unmarshal(data, shared)
+	if err != nil {
+		return nil, err
+	}
+
+	message, ok := wsMessages[shared.Type]
+	if !ok {
+		return nil, fmt.Errorf("Unknown event type: %s", shared.Type)
+	}
+	payload := message.payload()
+	err = json.Unmarshal(shared.Payload, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Event{
+		Type:           shared.Type,
+		GameID:         shared.GameID,
+		TeamID:         shared.TeamID,
+		ModeratorsOnly: shared.ModeratorsOnly,
+		PlayersOnly:    shared.PlayersOnly,
+		Payload:        payload,
+	}, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBroadcasts is a broadcastStore in memory. Inserts normally commit at
+// once; reserve and commit stand in for an insert that commits late.
+type fakeBroadcasts struct {
+	mu     sync.Mutex
+	rows   []*fakeBroadcast
+	nextID int64
+}
+
+type fakeBroadcast struct {
+	broadcast
+	created   time.Time
+	committed bool
+}
+
+func (store *fakeBroadcasts) reserve(instance string, event []byte) int64 {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.nextID++
+	store.rows = append(store.rows, &fakeBroadcast{
+		broadcast: broadcast{id: store.nextID, instance: instance, event: event},
+		created:   time.Now(),
+	})
+	return store.nextID
+}
+
+func (store *fakeBroadcasts) commit(id int64) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, row := range store.rows {
+		if row.id == id {
+			row.committed = true
+		}
+	}
+}
+
+func (store *fakeBroadcasts) age(d time.Duration) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, row := range store.rows {
+		row.created = row.created.Add(-d)
+	}
+}
+
+func (store *fakeBroadcasts) addBroadcast(instance string, event []byte) error {
+	store.commit(store.reserve(instance, event))
+	return nil
+}
+
+func (store *fakeBroadcasts) broadcasts(afterID int64, grace time.Duration) ([]*broadcast, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	broadcasts := []*broadcast{}
+	for _, row := range store.rows {
+		if row.committed && (row.id > afterID || !row.created.Before(time.Now().Add(-grace))) {
+			b := row.broadcast
+			broadcasts = append(broadcasts, &b)
+		}
+	}
+	return broadcasts, nil
+}
+
+func (store *fakeBroadcasts) deleteBroadcasts(retention time.Duration) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	rows := []*fakeBroadcast{}
+	for _, row := range store.rows {
+		if !row.created.Before(time.Now().Add(-retention)) {
+			rows = append(rows, row)
+		}
+	}
+	store.rows = rows
+	return nil
+}
+
+// testInstance is a backplane with a subscriber that records what it gets.
+type testInstance struct {
+	backplane *sharedBackplane
+	mu        sync.Mutex
+	received  []string
+}
+
+func newTestInstance(t *testing.T, store broadcastStore) *testInstance {
+	backplane, err := newSharedBackplane(store, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := &testInstance{backplane: backplane}
+	backplane.Subscribe(func(event *Event) {
+		instance.mu.Lock()
+		defer instance.mu.Unlock()
+		instance.received = append(instance.received, event.Payload.(*AnswerSubmittedPayload).AnswerID)
+	})
+	return instance
+}
+
+func (instance *testInstance) receive(t *testing.T) []string {
+	err := instance.backplane.receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+	received := instance.received
+	instance.received = nil
+	return received
+}
+
+func testEvent(answerID string) *Event {
+	return &Event{
+		Type:    MsgAnswerSubmitted,
+		GameID:  "game",
+		TeamID:  "team",
+		Payload: &AnswerSubmittedPayload{TeamID: "team", AnswerID: answerID},
+	}
+}
+
+func sharedEventData(t *testing.T, answerID string) []byte {
+	store := &fakeBroadcasts{}
+	backplane, err := newSharedBackplane(store, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backplane.Publish(testEvent(answerID))
+	return store.rows[0].event
+}
+
+func checkReceived(t *testing.T, name string, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s got %v, want %v", name, got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("%s got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestSharedBackplaneReachesOtherInstances(t *testing.T) {
+	store := &fakeBroadcasts{}
+	a := newTestInstance(t, store)
+	b := newTestInstance(t, store)
+
+	a.backplane.Publish(testEvent("1"))
+	checkReceived(t, "publisher", a.receive(t), "1")
+	checkReceived(t, "other instance", b.receive(t), "1")
+
+	checkReceived(t, "publisher again", a.receive(t))
+	checkReceived(t, "other instance again", b.receive(t))
+}
+
+func TestSharedBackplaneKeepsLocalEvents(t *testing.T) {
+	store := &fakeBroadcasts{}
+	a := newTestInstance(t, store)
+	b := newTestInstance(t, store)
+
+	event := testEvent("1")
+	event.Local = true
+	a.backplane.Publish(event)
+	checkReceived(t, "publisher", a.receive(t), "1")
+	checkReceived(t, "other instance", b.receive(t))
+}
+
+func TestSharedBackplaneSkipsEarlierEvents(t *testing.T) {
+	store := &fakeBroadcasts{}
+	a := newTestInstance(t, store)
+	a.backplane.Publish(testEvent("1"))
+
+	b := newTestInstance(t, store)
+	checkReceived(t, "late instance", b.receive(t))
+
+	a.backplane.Publish(testEvent("2"))
+	checkReceived(t, "late instance", b.receive(t), "2")
+}
+
+func TestSharedBackplaneLateCommit(t *testing.T) {
+	store := &fakeBroadcasts{}
+	b := newTestInstance(t, store)
+
+	late := store.reserve("a", sharedEventData(t, "1"))
+	store.addBroadcast("a", sharedEventData(t, "2"))
+	checkReceived(t, "before the late commit", b.receive(t), "2")
+
+	store.commit(late)
+	checkReceived(t, "after the late commit", b.receive(t), "1")
+	checkReceived(t, "again", b.receive(t))
+}
+
+func TestSharedBackplaneForgetsOldIDs(t *testing.T) {
+	store := &fakeBroadcasts{}
+	b := newTestInstance(t, store)
+
+	store.addBroadcast("a", sharedEventData(t, "1"))
+	store.addBroadcast("a", sharedEventData(t, "2"))
+	checkReceived(t, "new events", b.receive(t), "1", "2")
+
+	store.age(time.Minute)
+	checkReceived(t, "old events", b.receive(t))
+	if len(b.backplane.seen) != 0 {
+		t.Errorf("still remembers %d ids past the grace period", len(b.backplane.seen))
+	}
+
+	store.addBroadcast("a", sharedEventData(t, "3"))
+	checkReceived(t, "next event", b.receive(t), "3")
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// Club struct
+type Club struct {
+	ID       string        `json:"id"`
+	Name     string        `json:"name"`
+	Division string        `json:"division"`
+	Created  time.Time     `json:"created"`
+	Members  []*TeamMember `json:"members"`
+}
+
+// ClubStats struct
+type ClubStats struct {
+	ClubID  string      `json:"clubId"`
+	Name    string      `json:"name"`
+	Season  int         `json:"season"`
+	Games   int         `json:"games"`
+	Wins    int         `json:"wins"`
+	Points  float64     `json:"points"`
+	Average float64     `json:"average"`
+	Correct int         `json:"correct"`
+	Wrong   int         `json:"wrong"`
+	Results []*ClubGame `json:"results"`
+}
+
+// ClubGame struct
+type ClubGame struct {
+	GameID   string    `json:"gameId"`
+	GameName string    `json:"gameName"`
+	TeamID   string    `json:"teamId"`
+	Created  time.Time `json:"created"`
+	Points   float64   `json:"points"`
+	Rank     int       `json:"rank"`
+	Teams    int       `json:"teams"`
+}
+
+// teamMembers is the roster of every team: the users added to the team itself
+// plus the members of the club the team plays for.
+const teamMembers = `(
+	select team_id, user_id, role from pbe.team_members
+	union
+	select t.id, cm.user_id, cm.role
+	from pbe.club_members cm
+	inner join pbe.teams t on t.club_id = cm.club_id
+)`
+
+func (s *Server) getClubsController(c echo.Context) error {
+	conn := s.db.WithContext(c.Request().Context())
+
+	rows, err := conn.Query(`
+		select id, name, coalesce(division, ''), created
+		from pbe.clubs
+		order by division, name
+	`)
+	if err != nil {
+		log.Error("Could not get clubs: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get clubs: "+err.Error())
+	}
+	defer rows.Close()
+
+	clubs := []*Club{}
+	for rows.Next() {
+		var created string
+		club := &Club{}
+		err = rows.Scan(&club.ID, &club.Name, &club.Division, &created)
+		if err != nil {
+			log.Error("Could not get club: ", err)
+			return c.JSON(http.StatusInternalServerError, "Could not get club: "+err.Error())
+		}
+		club.Created, _ = time.Parse("2006-01-02 15:04:05", created)
+		clubs = append(clubs, club)
+	}
+
+	return c.JSON(http.StatusOK, clubs)
+}
+
+func (s *Server) getClubController(c echo.Context) error {
+	clubID := c.Param("clubID")
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	club, err := getClub(conn, clubID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, "Club not found: "+clubID)
+	}
+	if err != nil {
+		log.Error("Could not get club: ", clubID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get club: "+clubID+" : "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, club)
+}
+
+func getClub(conn *Conn, clubID string) (*Club, error) {
+	var created string
+	club := &Club{ID: clubID}
+	err := conn.QueryRow(`
+		select name, coalesce(division, ''), created
+		from pbe.clubs
+		where id = ?
+	`, clubID).Scan(&club.Name, &club.Division, &created)
+	if err != nil {
+		return nil, err
+	}
+	club.Created, _ = time.Parse("2006-01-02 15:04:05", created)
+
+	rows, err := conn.Query(`
+		select u.id, u.first_name, u.last_name, coalesce(u.email, ''), cm.role
+		from pbe.club_members cm
+		inner join users u on u.id = cm.user_id
+		where cm.club_id = ?
+		order by cm.role, u.last_name, u.first_name
+	`, clubID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	club.Members = []*TeamMember{}
+	for rows.Next() {
+		member := &TeamMember{}
+		err = rows.Scan(&member.UserID, &member.FirstName, &member.LastName, &member.Email, &member.Role)
+		if err != nil {
+			return nil, err
+		}
+		club.Members = append(club.Members, member)
+	}
+	return club, rows.Err()
+}
+
+func (s *Server) addClubController(c echo.Context) error {
+	club := &Club{}
+	err := c.Bind(&club)
+	if err != nil {
+		log.Error("Could not parse club: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse club: "+err.Error())
+	}
+	if len(strings.TrimSpace(club.Name)) == 0 {
+		return c.JSON(http.StatusBadRequest, "name is required")
+	}
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	club.ID, _ = UUID()
+	club.Created = time.Now()
+	_, err = conn.Exec(`
+		insert into pbe.clubs(id, name, division, created)
+		values(?,?,?,NOW())
+	`, club.ID, club.Name, club.Division)
+	if err != nil {
+		log.Error("Could not create club: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not create club: "+err.Error())
+	}
+
+	club.Members = []*TeamMember{}
+	return c.JSON(http.StatusOK, club)
+}
+
+func (s *Server) updateClubController(c echo.Context) error {
+	clubID := c.Param("clubID")
+	club := &Club{}
+	err := c.Bind(&club)
+	if err != nil {
+		log.Error("Could not parse club: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse club: "+err.Error())
+	}
+	if len(strings.TrimSpace(club.Name)) == 0 {
+		return c.JSON(http.StatusBadRequest, "name is required")
+	}
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	_, err = conn.Exec(`
+		update pbe.clubs set name = ?, division = ? where id = ?
+	`, club.Name, club.Division, clubID)
+	if err != nil {
+		log.Error("Could not update club: ", clubID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not update club: "+clubID+" : "+err.Error())
+	}
+
+	return s.getClubController(c)
+}
+
+// deleteClubController removes the club. Teams it played as in past games are
+// kept, they just no longer belong to a club.
+func (s *Server) deleteClubController(c echo.Context) error {
+	clubID := c.Param("clubID")
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	_, err := conn.Exec(`
+		delete from pbe.clubs where id = ?
+	`, clubID)
+	if err != nil {
+		log.Error("Could not delete club: ", clubID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not delete club: "+clubID+" : "+err.Error())
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (s *Server) addClubMemberController(c echo.Context) error {
+	clubID := c.Param("clubID")
+	member := &TeamMember{}
+	err := c.Bind(&member)
+	if err != nil {
+		log.Error("Could not parse club member: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse club member: "+err.Error())
+	}
+
+	if len(member.Role) == 0 {
+		member.Role = RolePathfinder
+	}
+	if member.Role != RolePathfinder && member.Role != RoleCounselor {
+		return c.JSON(http.StatusBadRequest, "Club members must be a "+RolePathfinder+" or a "+RoleCounselor)
+	}
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	err = conn.QueryRow(`
+		select id, first_name, last_name, coalesce(email, '')
+		from users
+		where id = ? or (? <> '' and email = ?)
+	`, member.UserID, member.Email, member.Email).Scan(&member.UserID, &member.FirstName, &member.LastName, &member.Email)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, "User not found")
+	}
+	if err != nil {
+		log.Error("Could not get user: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get user: "+err.Error())
+	}
+
+	_, err = conn.Exec(`
+		insert into pbe.club_members(club_id, user_id, role)
+		values(?,?,?)
+		on duplicate key update role = values(role)
+	`, clubID, member.UserID, member.Role)
+	if err != nil {
+		log.Error("Could not add club member: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not add club member: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, member)
+}
+
+func (s *Server) deleteClubMemberController(c echo.Context) error {
+	clubID := c.Param("clubID")
+	userID := c.Param("userID")
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	_, err := conn.Exec(`
+		delete from pbe.club_members where club_id = ? and user_id = ?
+	`, clubID, userID)
+	if err != nil {
+		log.Error("Could not delete club member: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not delete club member: "+err.Error())
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// enrollClubController adds a club to an open game as a team that keeps the
+// club's name and members.
+func (s *Server) enrollClubController(c echo.Context) error {
+	gameID := c.Param("gameID")
+	clubID := c.Param("clubID")
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	tx, err := conn.Begin()
+	if err != nil {
+		log.Error("Could not create database transaction: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not create database transaction: "+err.Error())
+	}
+
+	_, err = requireGameStatus(tx, gameID, GameOpen)
+	if err != nil {
+		log.Error("Could not enroll club: ", clubID, " : ", err)
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Game not found: "+gameID)
+		}
+		return c.JSON(gameErrorStatus(err), "Could not enroll club: "+clubID+" : "+err.Error())
+	}
+
+	team, err := enrollClub(tx, gameID, clubID)
+	if _, ok := err.(*ClubNotFoundError); ok {
+		tx.Rollback()
+		return c.JSON(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		log.Error("Could not enroll club: ", clubID, " : ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not enroll club: "+clubID+" : "+err.Error())
+	}
+
+	tx.Commit()
+
+	return c.JSON(http.StatusOK, team)
+}
+
+// ClubNotFoundError is returned when enrolling or seeding a club that does not
+// exist.
+type ClubNotFoundError struct {
+	ClubID string
+}
+
+func (e *ClubNotFoundError) Error() string {
+	return "Club not found: " + e.ClubID
+}
+
+// requireClub returns a *ClubNotFoundError unless the club exists.
+func requireClub(q queryRower, clubID string) error {
+	var count int
+	err := q.QueryRow(`
+		select count(*) from pbe.clubs where id = ?
+	`, clubID).Scan(&count)
+	if err == nil && count == 0 {
+		err = &ClubNotFoundError{ClubID: clubID}
+	}
+	return err
+}
+
+// enrollClub returns the club's team in the game, creating it the first time.
+func enrollClub(tx *sql.Tx, gameID, clubID string) (*Team, error) {
+	team := &Team{ClubID: clubID}
+	err := tx.QueryRow(`
+		select id, name from pbe.teams where game_id = ? and club_id = ?
+	`, gameID, clubID).Scan(&team.ID, &team.Name)
+	if err == nil {
+		return team, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	err = tx.QueryRow(`
+		select name from pbe.clubs where id = ?
+	`, clubID).Scan(&team.Name)
+	if err == sql.ErrNoRows {
+		return nil, &ClubNotFoundError{ClubID: clubID}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	team.ID, _ = UUID()
+	_, err = tx.Exec(`
+		insert into pbe.teams(id, name, game_id, club_id)
+		values(?,?,?,?)
+	`, team.ID, team.Name, gameID, clubID)
+	return team, err
+}
+
+// getClubStatsController rolls up the club's finished games. The season query
+// parameter limits it to games created in that year.
+func (s *Server) getClubStatsController(c echo.Context) error {
+	clubID := c.Param("clubID")
+	season, _ := strconv.Atoi(c.QueryParam("season"))
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	club, err := getClub(conn, clubID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, "Club not found: "+clubID)
+	}
+	if err != nil {
+		log.Error("Could not get club: ", clubID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get club: "+clubID+" : "+err.Error())
+	}
+
+	rows, err := conn.Query(`
+		select g.id, g.name, g.created, t.id
+		from pbe.teams t
+		inner join pbe.games g on g.id = t.game_id
+		where t.club_id = ?
+		and g.status = 'FINISHED'
+		and (? = 0 or year(g.created) = ?)
+		order by g.created
+	`, clubID, season, season)
+	if err != nil {
+		log.Error("Could not get club games: ", clubID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get club games: "+clubID+" : "+err.Error())
+	}
+
+	results := []*ClubGame{}
+	for rows.Next() {
+		var created string
+		result := &ClubGame{}
+		err = rows.Scan(&result.GameID, &result.GameName, &created, &result.TeamID)
+		if err != nil {
+			rows.Close()
+			log.Error("Could not get club game: ", err)
+			return c.JSON(http.StatusInternalServerError, "Could not get club game: "+err.Error())
+		}
+		result.Created, _ = time.Parse("2006-01-02 15:04:05", created)
+		results = append(results, result)
+	}
+	rows.Close()
+
+	stats := &ClubStats{
+		ClubID:  club.ID,
+		Name:    club.Name,
+		Season:  season,
+		Results: results,
+	}
+	for _, result := range results {
+		scoreboard, err := getScoreboard(conn, result.GameID)
+		if err != nil {
+			log.Error("Could not get scoreboard: ", result.GameID, " : ", err)
+			return c.JSON(http.StatusInternalServerError, "Could not get scoreboard: "+result.GameID+" : "+err.Error())
+		}
+
+		result.Teams = len(scoreboard.Teams)
+		for _, team := range scoreboard.Teams {
+			if team.TeamID != result.TeamID {
+				continue
+			}
+			result.Points = team.Points
+			result.Rank = team.Rank
+			for _, score := range team.Scores {
+				stats.Correct += score.Correct
+				stats.Wrong += score.Wrong
+			}
+		}
+
+		stats.Games++
+		stats.Points += result.Points
+		if result.Rank == 1 && result.Teams > 1 {
+			stats.Wins++
+		}
+	}
+	if stats.Games > 0 {
+		stats.Average = round2(stats.Points / float64(stats.Games))
+	}
+	stats.Points = round2(stats.Points)
+
+	return c.JSON(http.StatusOK, stats)
+}
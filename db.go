@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/spf13/viper"
+)
+
+// DB is the connection pool every handler shares.
+type DB struct {
+	db *sql.DB
+}
+
+// Conn runs queries on the pool under a context, so a request's queries are
+// abandoned when its client goes away.
+type Conn struct {
+	db  *sql.DB
+	ctx context.Context
+}
+
+// openDB opens the pool described by database.url. database.maxOpenConns,
+// database.maxIdleConns and database.connMaxLifetime size it.
+func openDB() (*DB, error) {
+	viper.SetDefault("database.maxOpenConns", 25)
+	viper.SetDefault("database.maxIdleConns", 10)
+	viper.SetDefault("database.connMaxLifetime", "5m")
+
+	db, err := sql.Open("mysql", viper.GetString("database.url"))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(viper.GetInt("database.maxOpenConns"))
+	db.SetMaxIdleConns(viper.GetInt("database.maxIdleConns"))
+	db.SetConnMaxLifetime(viper.GetDuration("database.connMaxLifetime"))
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
+// Close closes the pool.
+func (db *DB) Close() error {
+	return db.db.Close()
+}
+
+// WithContext returns a connection whose queries run under ctx.
+func (db *DB) WithContext(ctx context.Context) *Conn {
+	return &Conn{db: db.db, ctx: ctx}
+}
+
+// Query runs a query that returns rows.
+func (conn *Conn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return conn.db.QueryContext(conn.ctx, query, args...)
+}
+
+// QueryRow runs a query that returns at most one row.
+func (conn *Conn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return conn.db.QueryRowContext(conn.ctx, query, args...)
+}
+
+// Exec runs a statement that returns no rows.
+func (conn *Conn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return conn.db.ExecContext(conn.ctx, query, args...)
+}
+
+// Begin starts a transaction that is rolled back if the context ends before
+// it is committed.
+func (conn *Conn) Begin() (*sql.Tx, error) {
+	return conn.db.BeginTx(conn.ctx, nil)
+}
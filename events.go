@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/labstack/gommon/log"
+)
+
+// Event is a change clients should hear about, published once it is
+// committed. Type is the websocket message type that carries Payload. Events
+// without a GameID are about the list of games rather than one game. Events
+// with a TeamID only reach that team and moderators, ModeratorsOnly events
+// only reach moderators and PlayersOnly events everyone but moderators. Local
+// events stay on the instance that published them, for events every instance
+// publishes itself.
+type Event struct {
+	Type           string
+	GameID         string
+	TeamID         string
+	ModeratorsOnly bool
+	PlayersOnly    bool
+	Local          bool
+	Payload        wsPayload
+}
+
+// Backplane carries published events to the subscribers on every server
+// instance.
+type Backplane interface {
+	Publish(event *Event)
+	Subscribe(fn func(event *Event))
+}
+
+// EventBus is the in-process Backplane, for a single instance. It hands every
+// published event to each subscriber in turn, on the publisher's goroutine, so
+// subscribers must not block.
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers []func(event *Event)
+}
+
+func newEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe calls fn with every event published from now on.
+func (bus *EventBus) Subscribe(fn func(event *Event)) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	bus.subscribers = append(bus.subscribers, fn)
+}
+
+// Publish hands the event to every subscriber.
+func (bus *EventBus) Publish(event *Event) {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+
+	for _, fn := range bus.subscribers {
+		fn(event)
+	}
+}
+
+// publishGame tells the game's clients its current question and the scores
+// so far, and that the game is over once it has finished or been cancelled.
+func (s *Server) publishGame(gameID string) {
+	ctx := context.Background()
+
+	question, err := s.store.CurrentQuestion(ctx, gameID)
+	if err != nil {
+		log.Error("Could not get the current question: ", gameID, " : ", err)
+		return
+	}
+	s.bus.Publish(&Event{
+		Type:    MsgQuestionChanged,
+		GameID:  gameID,
+		Payload: &QuestionChangedPayload{Question: question},
+	})
+	if !question.Finished {
+		s.publishScoreboard(gameID)
+		return
+	}
+
+	scoreboard, err := s.store.Scoreboard(ctx, gameID, false)
+	if err != nil {
+		log.Error("Could not get scoreboard: ", gameID, " : ", err)
+		return
+	}
+	s.bus.Publish(&Event{
+		Type:    MsgGameFinished,
+		GameID:  gameID,
+		Payload: &GameFinishedPayload{Status: scoreboard.Status, Scoreboard: scoreboard},
+	})
+}
+
+// publishScoreboard tells the game's moderators its current scores and its
+// players the scores they may see.
+func (s *Server) publishScoreboard(gameID string) {
+	ctx := context.Background()
+
+	scoreboard, err := s.store.Scoreboard(ctx, gameID, false)
+	if err != nil {
+		log.Error("Could not get scoreboard: ", gameID, " : ", err)
+		return
+	}
+	s.bus.Publish(&Event{Type: MsgScoreboard, GameID: gameID, ModeratorsOnly: true, Payload: scoreboard})
+
+	scoreboard, err = s.store.Scoreboard(ctx, gameID, true)
+	if err != nil {
+		log.Error("Could not get scoreboard: ", gameID, " : ", err)
+		return
+	}
+	s.bus.Publish(&Event{Type: MsgScoreboard, GameID: gameID, PlayersOnly: true, Payload: scoreboard})
+}
+
+// publishTimer tells the game's clients its countdown.
+func (s *Server) publishTimer(gameID string) {
+	timer, err := s.store.GameTimer(context.Background(), gameID)
+	if err != nil {
+		log.Error("Could not get game timer: ", gameID, " : ", err)
+		return
+	}
+	s.bus.Publish(&Event{Type: MsgTimerTick, GameID: gameID, Payload: timer})
+}
+
+// publishGames tells clients on /ws/pbe/teams the games have changed.
+func (s *Server) publishGames() {
+	games, err := s.store.Games(context.Background())
+	if err != nil {
+		log.Error("Could not get updated games: ", err)
+		return
+	}
+	s.bus.Publish(&Event{Type: MsgGames, Payload: &GamesPayload{Games: games}})
+}
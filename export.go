@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// exportQuestionsController writes the question bank, filtered the same way as
+// the questions list. The json and csv formats can be fed back into the import
+// endpoint, while text and markdown produce a printable study sheet grouped by
+// chapter and verse.
+func (s *Server) exportQuestionsController(c echo.Context) error {
+	filter, err := questionFilterFromQuery(c)
+	if err != nil {
+		log.Error("Could not parse question filter: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse question filter: "+err.Error())
+	}
+	book := filter.Book
+	chapter := filter.Chapter
+	format := strings.ToLower(c.QueryParam("format"))
+	if len(format) == 0 {
+		format = "json"
+	}
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	questions, _, err := getQuestions(conn, filter)
+	if err != nil {
+		log.Error("Could not get questions: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get questions: "+err.Error())
+	}
+
+	var (
+		buf         bytes.Buffer
+		contentType string
+		extension   string
+	)
+	switch format {
+	case "json":
+		for _, question := range questions {
+			if question.Answers == nil {
+				question.Answers = []*Answer{}
+			}
+		}
+		c.Response().Header().Set(echo.HeaderContentDisposition, "attachment; filename="+exportFilename(book, chapter, "json"))
+		return c.JSON(http.StatusOK, questions)
+	case "csv":
+		err = writeQuestionsCSV(&buf, questions)
+		contentType = "text/csv; charset=UTF-8"
+		extension = "csv"
+	case "text", "txt":
+		writeStudySheet(&buf, questions, false)
+		contentType = echo.MIMETextPlainCharsetUTF8
+		extension = "txt"
+	case "markdown", "md":
+		writeStudySheet(&buf, questions, true)
+		contentType = "text/markdown; charset=UTF-8"
+		extension = "md"
+	default:
+		log.Error("Unknown export format: ", format)
+		return c.JSON(http.StatusBadRequest, "Unknown export format: "+format)
+	}
+	if err != nil {
+		log.Error("Could not export questions: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not export questions: "+err.Error())
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, "attachment; filename="+exportFilename(book, chapter, extension))
+	return c.Blob(http.StatusOK, contentType, buf.Bytes())
+}
+
+func exportFilename(book, chapter, extension string) string {
+	parts := []string{"questions"}
+	if len(book) > 0 {
+		parts = append(parts, book)
+	}
+	if len(chapter) > 0 {
+		parts = append(parts, chapter)
+	}
+	name := strings.Join(parts, "-")
+	name = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '"' || r == '/' || r == '\\' || r == ';' {
+			return '_'
+		}
+		return r
+	}, name)
+	return name + "." + extension
+}
+
+// writeQuestionsCSV uses the same column layout the import endpoint reads:
+// book, chapter, verses, question and then answer/correct pairs.
+func writeQuestionsCSV(buf *bytes.Buffer, questions []*Question) error {
+	maxAnswers := 0
+	for _, question := range questions {
+		if len(question.Answers) > maxAnswers {
+			maxAnswers = len(question.Answers)
+		}
+	}
+
+	writer := csv.NewWriter(buf)
+	header := []string{"book", "chapter", "verses", "question"}
+	for i := 1; i <= maxAnswers; i++ {
+		header = append(header, fmt.Sprintf("answer%d", i), fmt.Sprintf("correct%d", i))
+	}
+	err := writer.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for _, question := range questions {
+		record := []string{question.Book, question.Chapter, question.Verses, question.Question}
+		for _, answer := range question.Answers {
+			record = append(record, answer.Answer, strconv.FormatBool(answer.Status))
+		}
+		err = writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeStudySheet groups questions by book and chapter, then by verses, and
+// marks the correct answers.
+func writeStudySheet(buf *bytes.Buffer, questions []*Question, markdown bool) {
+	var (
+		chapter string
+		verses  string
+		number  int
+	)
+	for _, question := range questions {
+		title := question.Book + " " + question.Chapter
+		if title != chapter {
+			chapter = title
+			verses = ""
+			number = 0
+			if buf.Len() > 0 {
+				buf.WriteString("\n")
+			}
+			if markdown {
+				fmt.Fprintf(buf, "# %s\n", title)
+			} else {
+				fmt.Fprintf(buf, "%s\n%s\n", title, strings.Repeat("=", len(title)))
+			}
+		}
+		if question.Verses != verses {
+			verses = question.Verses
+			if markdown {
+				fmt.Fprintf(buf, "\n## Verses %s\n\n", verses)
+			} else {
+				fmt.Fprintf(buf, "\nVerses %s\n\n", verses)
+			}
+		}
+
+		number++
+		fmt.Fprintf(buf, "%d. %s\n", number, question.Question)
+		for _, answer := range question.Answers {
+			switch {
+			case markdown && answer.Status:
+				fmt.Fprintf(buf, "   - **%s** ✓\n", answer.Answer)
+			case markdown:
+				fmt.Fprintf(buf, "   - %s\n", answer.Answer)
+			case answer.Status:
+				fmt.Fprintf(buf, "   [x] %s\n", answer.Answer)
+			default:
+				fmt.Fprintf(buf, "   [ ] %s\n", answer.Answer)
+			}
+		}
+	}
+}
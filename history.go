@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// QuestionHistory struct
+type QuestionHistory struct {
+	GameID         string               `json:"gameId"`
+	GameName       string               `json:"gameName"`
+	GameQuestionID string               `json:"gameQuestionId"`
+	Position       int                  `json:"position"`
+	Started        *time.Time           `json:"started"`
+	Correct        int                  `json:"correct"`
+	Wrong          int                  `json:"wrong"`
+	Answers        []*TeamAnswerHistory `json:"answers"`
+}
+
+// TeamAnswerHistory struct
+type TeamAnswerHistory struct {
+	TeamID   string    `json:"teamId"`
+	TeamName string    `json:"teamName"`
+	AnswerID string    `json:"answerId"`
+	Answer   string    `json:"answer"`
+	Status   bool      `json:"status"`
+	Late     bool      `json:"late"`
+	Created  time.Time `json:"created"`
+}
+
+// getQuestionHistoryController lists every game the question was asked in,
+// newest first, with what each team answered to it there.
+func (s *Server) getQuestionHistoryController(c echo.Context) error {
+	questionID := c.Param("questionID")
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	rows, err := conn.Query(`
+		select g.id, g.name, gq.id, gq.position, coalesce(gq.started, ''),
+			coalesce(t.id, ''), coalesce(t.name, ''), coalesce(a.id, ''), coalesce(a.answer, ''),
+			coalesce(ta.ruling, a.status, 0) = 1, coalesce(ta.late, 0) = 1, coalesce(ta.created, '')
+		from pbe.game_questions gq
+		inner join pbe.games g on g.id = gq.game_id
+		left join pbe.team_answers ta on ta.game_question_id = gq.id
+		left join pbe.teams t on t.id = ta.team_id
+		left join pbe.answers a on a.id = ta.answer_id
+		where gq.question_id = ?
+		order by g.created desc, g.id, t.name, ta.created
+	`, questionID)
+	if err != nil {
+		log.Error("Could not get question history: ", questionID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get question history: "+questionID+" : "+err.Error())
+	}
+	defer rows.Close()
+
+	history := []*QuestionHistory{}
+	entry := &QuestionHistory{}
+	for rows.Next() {
+		var started, created string
+		var gameID, gameName, gameQuestionID string
+		var position int
+		answer := &TeamAnswerHistory{}
+		err = rows.Scan(&gameID, &gameName, &gameQuestionID, &position, &started,
+			&answer.TeamID, &answer.TeamName, &answer.AnswerID, &answer.Answer,
+			&answer.Status, &answer.Late, &created)
+		if err != nil {
+			log.Error("Could not get question history: ", err)
+			return c.JSON(http.StatusInternalServerError, "Could not get question history: "+err.Error())
+		}
+
+		if entry.GameQuestionID != gameQuestionID {
+			entry = &QuestionHistory{
+				GameID:         gameID,
+				GameName:       gameName,
+				GameQuestionID: gameQuestionID,
+				Position:       position,
+				Answers:        []*TeamAnswerHistory{},
+			}
+			if date, err := time.Parse("2006-01-02 15:04:05", started); err == nil {
+				entry.Started = &date
+			}
+			history = append(history, entry)
+		}
+
+		if len(answer.AnswerID) == 0 {
+			continue
+		}
+		answer.Created, _ = time.Parse("2006-01-02 15:04:05", created)
+		if answer.Status {
+			entry.Correct++
+		} else {
+			entry.Wrong++
+		}
+		entry.Answers = append(entry.Answers, answer)
+	}
+
+	return c.JSON(http.StatusOK, history)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// ImportError struct
+type ImportError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+// ImportReport struct
+type ImportReport struct {
+	Format    string         `json:"format"`
+	DryRun    bool           `json:"dryRun"`
+	Total     int            `json:"total"`
+	Imported  int            `json:"imported"`
+	Errors    []*ImportError `json:"errors"`
+	Questions []*Question    `json:"questions"`
+}
+
+// importQuestionsController reads a CSV or JSON question bank, validates every
+// row and inserts all of them in one transaction. Nothing is inserted when a
+// single row fails or when dryRun is requested.
+//
+// CSV files need a header row. The first four columns are book, chapter,
+// verses and question, followed by any number of answer/correct column pairs.
+// JSON files are an array of questions in the same shape as the questions API.
+func (s *Server) importQuestionsController(c echo.Context) error {
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dryRun"))
+
+	body, name, err := importBody(c)
+	if err != nil {
+		log.Error("Could not read import file: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not read import file: "+err.Error())
+	}
+	defer body.Close()
+
+	format := importFormat(c, name)
+	report := &ImportReport{
+		Format: format,
+		DryRun: dryRun,
+		Errors: []*ImportError{},
+	}
+
+	var questions []*Question
+	switch format {
+	case "csv":
+		questions, err = parseQuestionsCSV(body, report)
+	case "json":
+		questions, err = parseQuestionsJSON(body)
+	default:
+		log.Error("Unknown import format: ", format)
+		return c.JSON(http.StatusBadRequest, "Unknown import format: "+format)
+	}
+	if err != nil {
+		log.Error("Could not parse import file: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse import file: "+err.Error())
+	}
+
+	report.Total = len(questions)
+	for i, question := range questions {
+		for _, msg := range validateQuestion(question) {
+			report.Errors = append(report.Errors, &ImportError{Row: i + 1, Message: msg})
+		}
+	}
+	report.Questions = questions
+
+	if len(report.Errors) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, report)
+	}
+	if dryRun {
+		return c.JSON(http.StatusOK, report)
+	}
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	tx, err := conn.Begin()
+	if err != nil {
+		log.Error("Could not create database transaction: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not create database transaction: "+err.Error())
+	}
+
+	for i, question := range questions {
+		err = insertQuestion(tx, question)
+		if err != nil {
+			log.Error("Could not import question on row: ", i+1, " : ", err)
+			tx.Rollback()
+			report.Errors = append(report.Errors, &ImportError{Row: i + 1, Message: err.Error()})
+			return c.JSON(http.StatusInternalServerError, report)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Error("Could not import questions: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not import questions: "+err.Error())
+	}
+	report.Imported = len(questions)
+	return c.JSON(http.StatusOK, report)
+}
+
+// importBody returns the uploaded file when the request is a multipart form,
+// otherwise the raw request body.
+func importBody(c echo.Context) (io.ReadCloser, string, error) {
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		header, err := c.FormFile("file")
+		if err != nil {
+			return nil, "", err
+		}
+		file, err := header.Open()
+		if err != nil {
+			return nil, "", err
+		}
+		return file, header.Filename, nil
+	}
+	return c.Request().Body, "", nil
+}
+
+func importFormat(c echo.Context, name string) string {
+	format := strings.ToLower(c.QueryParam("format"))
+	if len(format) > 0 {
+		return format
+	}
+	if strings.HasSuffix(strings.ToLower(name), ".csv") {
+		return "csv"
+	}
+	if strings.HasSuffix(strings.ToLower(name), ".json") {
+		return "json"
+	}
+	if strings.Contains(c.Request().Header.Get(echo.HeaderContentType), "csv") {
+		return "csv"
+	}
+	return "json"
+}
+
+func parseQuestionsJSON(r io.Reader) ([]*Question, error) {
+	questions := []*Question{}
+	err := json.NewDecoder(r).Decode(&questions)
+	if err != nil {
+		return nil, err
+	}
+	return questions, nil
+}
+
+// parseQuestionsCSV returns one question per data row. Rows with a bad correct
+// flag are still returned so the rest of the row is validated, and the flag
+// problem is added to the report.
+func parseQuestionsCSV(r io.Reader, report *ImportReport) ([]*Question, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	_, err := reader.Read()
+	if err == io.EOF {
+		return []*Question{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	questions := []*Question{}
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for len(record) < 4 {
+			record = append(record, "")
+		}
+		question := &Question{
+			Book:     strings.TrimSpace(record[0]),
+			Chapter:  strings.TrimSpace(record[1]),
+			Verses:   strings.TrimSpace(record[2]),
+			Question: strings.TrimSpace(record[3]),
+		}
+		for i := 4; i < len(record); i += 2 {
+			text := strings.TrimSpace(record[i])
+			flag := ""
+			if i+1 < len(record) {
+				flag = strings.TrimSpace(record[i+1])
+			}
+			if len(text) == 0 && len(flag) == 0 {
+				continue
+			}
+			status, err := parseCorrect(flag)
+			if err != nil {
+				report.Errors = append(report.Errors, &ImportError{
+					Row:     row,
+					Message: fmt.Sprintf("answer %d: %v", (i-4)/2+1, err),
+				})
+			}
+			question.Answers = append(question.Answers, &Answer{
+				Answer: text,
+				Status: status,
+			})
+		}
+		questions = append(questions, question)
+	}
+	return questions, nil
+}
+
+func parseCorrect(flag string) (bool, error) {
+	switch strings.ToLower(flag) {
+	case "", "0", "f", "false", "n", "no", "incorrect", "wrong":
+		return false, nil
+	case "1", "t", "true", "y", "yes", "x", "correct":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid correct value %q", flag)
+}
+
+// validateQuestion returns every problem found in a question so the import
+// report can list them all at once.
+func validateQuestion(question *Question) []string {
+	errs := validateQuestionFields(question)
+	if len(question.Answers) == 0 {
+		errs = append(errs, "at least one answer is required")
+	}
+	correct := 0
+	for i, answer := range question.Answers {
+		errs = append(errs, validateAnswer(i, answer)...)
+		if answer.Status {
+			correct++
+		}
+	}
+	if len(question.Answers) > 0 && correct == 0 {
+		errs = append(errs, "at least one answer must be correct")
+	}
+	return errs
+}
+
+func validateQuestionFields(question *Question) []string {
+	errs := []string{}
+	required := []struct {
+		name  string
+		value string
+		max   int
+	}{
+		{"book", question.Book, 50},
+		{"chapter", question.Chapter, 50},
+		{"verses", question.Verses, 50},
+		{"question", question.Question, 500},
+	}
+	for _, field := range required {
+		if len(strings.TrimSpace(field.value)) == 0 {
+			errs = append(errs, field.name+" is required")
+		} else if utf8.RuneCountInString(field.value) > field.max {
+			errs = append(errs, fmt.Sprintf("%s is longer than %d characters", field.name, field.max))
+		}
+	}
+	return errs
+}
+
+func validateAnswer(i int, answer *Answer) []string {
+	if len(strings.TrimSpace(answer.Answer)) == 0 {
+		return []string{fmt.Sprintf("answer %d is empty", i+1)}
+	}
+	if utf8.RuneCountInString(answer.Answer) > 500 {
+		return []string{fmt.Sprintf("answer %d is longer than 500 characters", i+1)}
+	}
+	return nil
+}
+
+// insertQuestion assigns new IDs to the question and its answers and inserts
+// them in the given transaction.
+func insertQuestion(tx *sql.Tx, question *Question) error {
+	question.ID, _ = UUID()
+	_, err := tx.Exec(`
+		insert into pbe.questions(id, book, chapter, verses, question) values(?,?,?,?,?)
+	`, question.ID, question.Book, question.Chapter, question.Verses, question.Question)
+	if err != nil {
+		return fmt.Errorf("Could not create question: %v", err)
+	}
+
+	for _, answer := range question.Answers {
+		answer.ID, _ = UUID()
+		_, err = tx.Exec(`
+			insert into pbe.answers(id, answer, status, question_id)
+			values(?,?,?,?)
+		`, answer.ID, answer.Answer, answer.Status, question.ID)
+		if err != nil {
+			return fmt.Errorf("Could not create answer: %v", err)
+		}
+	}
+	return nil
+}
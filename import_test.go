@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestValidateQuestionCountsCharacters checks that length limits count
+// characters, not bytes, so accented text up to the limit imports.
+func TestValidateQuestionCountsCharacters(t *testing.T) {
+	question := &Question{
+		Book:     "Génesis",
+		Chapter:  "1",
+		Verses:   "1",
+		Question: strings.Repeat("é", 500),
+		Answers:  []*Answer{{Answer: strings.Repeat("ñ", 500), Status: true}},
+	}
+	if errs := validateQuestion(question); len(errs) > 0 {
+		t.Errorf("500 accented characters gave %v", errs)
+	}
+
+	question.Question += "é"
+	question.Answers[0].Answer += "ñ"
+	if errs := validateQuestion(question); len(errs) != 2 {
+		t.Errorf("501 characters gave %v, want two errors", errs)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// Game statuses
+const (
+	GameOpen      = "OPEN"
+	GameStarted   = "STARTED"
+	GamePaused    = "PAUSED"
+	GameFinished  = "FINISHED"
+	GameCancelled = "CANCELLED"
+)
+
+// gameTransitions lists the statuses a game can move to from each status.
+// FINISHED and CANCELLED are final.
+var gameTransitions = map[string][]string{
+	GameOpen:    {GameStarted, GameCancelled},
+	GameStarted: {GamePaused, GameFinished, GameCancelled},
+	GamePaused:  {GameStarted, GameFinished, GameCancelled},
+}
+
+// GameEvent struct
+type GameEvent struct {
+	ID      string    `json:"id"`
+	GameID  string    `json:"gameId"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	UserID  string    `json:"userId"`
+	Created time.Time `json:"created"`
+}
+
+// errNoQuestions is returned when a game's chapters have no questions to
+// start it with.
+var errNoQuestions = errors.New("Cannot start a game without questions")
+
+// TransitionError is returned when a game cannot move to the requested status.
+type TransitionError struct {
+	GameID string
+	From   string
+	To     string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("Game %s cannot go from %s to %s", e.GameID, e.From, e.To)
+}
+
+// StatusError is returned when an action needs the game in another status.
+type StatusError struct {
+	GameID   string
+	Status   string
+	Expected []string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Game %s is %s, expected %v", e.GameID, e.Status, e.Expected)
+}
+
+func canTransition(from, to string) bool {
+	for _, status := range gameTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// gameStatus locks the game row for the rest of the transaction and returns
+// its status.
+func gameStatus(tx *sql.Tx, gameID string) (string, error) {
+	var status string
+	err := tx.QueryRow(`
+		select coalesce(status, 'OPEN') from pbe.games where id = ? for update
+	`, gameID).Scan(&status)
+	return status, err
+}
+
+// requireGameStatus fails with a *StatusError unless the game is in one of the
+// given statuses.
+func requireGameStatus(tx *sql.Tx, gameID string, statuses ...string) (string, error) {
+	status, err := gameStatus(tx, gameID)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range statuses {
+		if s == status {
+			return status, nil
+		}
+	}
+	return status, &StatusError{GameID: gameID, Status: status, Expected: statuses}
+}
+
+// transitionGame moves the game to a new status and records who did it in
+// pbe.game_events. It returns a *TransitionError when the move is not allowed.
+func transitionGame(tx *sql.Tx, gameID, to, userID string) (string, error) {
+	from, err := gameStatus(tx, gameID)
+	if err != nil {
+		return "", err
+	}
+	if !canTransition(from, to) {
+		return from, &TransitionError{GameID: gameID, From: from, To: to}
+	}
+
+	_, err = tx.Exec(`
+		update pbe.games set status = ? where id = ?
+	`, to, gameID)
+	if err != nil {
+		return from, err
+	}
+
+	err = pauseGameTimer(tx, gameID, from, to)
+	if err != nil {
+		return from, err
+	}
+
+	err = addGameEvent(tx, gameID, from, to, userID)
+	return from, err
+}
+
+func addGameEvent(tx *sql.Tx, gameID, from, to, userID string) error {
+	id, _ := UUID()
+	_, err := tx.Exec(`
+		insert into pbe.game_events(id, game_id, from_status, to_status, user_id, created)
+		values(?,?,?,?,?,NOW())
+	`, id, gameID, from, to, userID)
+	return err
+}
+
+// gameErrorStatus maps lifecycle and answer rule errors, and starting a game
+// without questions, to 409 and missing rows and teams to 404.
+func gameErrorStatus(err error) int {
+	switch err.(type) {
+	case *TransitionError, *StatusError, *AnswerRuleError:
+		return http.StatusConflict
+	case *NotFoundError:
+		return http.StatusNotFound
+	}
+	switch err {
+	case sql.ErrNoRows:
+		return http.StatusNotFound
+	case errNoQuestions:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// currentUser returns the user_id claim of the request's JWT, if any.
+func currentUser(c echo.Context) string {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return ""
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	userID, _ := claims["user_id"].(string)
+	return userID
+}
+
+func (s *Server) pauseGameController(c echo.Context) error {
+	return s.transitionGameController(c, GamePaused)
+}
+
+// resumeGameController only restarts paused games; starting an open game
+// goes through startGameController.
+func (s *Server) resumeGameController(c echo.Context) error {
+	return s.transitionGameController(c, GameStarted, GamePaused)
+}
+
+func (s *Server) cancelGameController(c echo.Context) error {
+	return s.transitionGameController(c, GameCancelled)
+}
+
+// transitionGameController moves the game to the given status. When from
+// statuses are given, the game must be in one of them.
+func (s *Server) transitionGameController(c echo.Context, to string, from ...string) error {
+	gameID := c.Param("gameID")
+
+	err := s.store.TransitionGame(c.Request().Context(), gameID, to, currentUser(c), from...)
+	if err != nil {
+		log.Error("Could not change game status: ", gameID, " : ", err)
+		return c.JSON(gameErrorStatus(err), "Could not change game status: "+gameID+" : "+err.Error())
+	}
+
+	s.publishGame(gameID)
+	s.publishTimer(gameID)
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (s *Server) getGameEventsController(c echo.Context) error {
+	gameID := c.Param("gameID")
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	rows, err := conn.Query(`
+		select id, from_status, to_status, coalesce(user_id, ''), created
+		from pbe.game_events
+		where game_id = ?
+		order by seq
+	`, gameID)
+	if err != nil {
+		log.Error("Could not get game events: ", gameID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get game events: "+gameID+" : "+err.Error())
+	}
+	defer rows.Close()
+
+	events := []*GameEvent{}
+	for rows.Next() {
+		var created string
+		event := &GameEvent{GameID: gameID}
+		err = rows.Scan(&event.ID, &event.From, &event.To, &event.UserID, &created)
+		if err != nil {
+			log.Error("Could not get game event: ", err)
+			return c.JSON(http.StatusInternalServerError, "Could not get game event: "+err.Error())
+		}
+		event.Created, _ = time.Parse("2006-01-02 15:04:05", created)
+		events = append(events, event)
+	}
+
+	return c.JSON(http.StatusOK, events)
+}
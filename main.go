@@ -1,9 +1,11 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/rsa"
 	"fmt"
-	"strings"
+	"os"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/labstack/echo"
@@ -15,6 +17,15 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 )
 
+// Server holds what the handlers share. Handlers are its methods.
+type Server struct {
+	store Store
+	db    *DB
+	hub   *melody.Melody
+	bus   Backplane
+	key   *rsa.PublicKey
+}
+
 func main() {
 	viper.SetConfigName(".rocketeers")
 	viper.AddConfigPath("$HOME")
@@ -24,6 +35,21 @@ func main() {
 		panic(fmt.Errorf("Could not read configuration file: %s", err))
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		db, err := openDB()
+		if err != nil {
+			log.Error("Could not open database: ", err)
+			os.Exit(1)
+		}
+		err = migrateCommand(db.WithContext(context.Background()), os.Args[2:])
+		db.Close()
+		if err != nil {
+			log.Error("Could not migrate database: ", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	pem := viper.Get("key.public").(string)
 	key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(pem))
 	if err != nil {
@@ -65,74 +91,147 @@ func main() {
 		log.SetLevel(log.OFF)
 	}
 
+	store, db, err := openStore()
+	if err != nil {
+		log.Error("Could not open database: ", err)
+		panic(fmt.Errorf("Could not open database: %s", err))
+	}
+	if db != nil {
+		defer db.Close()
+
+		err = checkSchema(db.WithContext(context.Background()))
+		if err != nil {
+			log.Error("Could not start: ", err)
+			panic(err)
+		}
+	} else {
+		log.Warn("Running without MySQL: games and team rosters work, but question import, export and history, typed responses, appeals, game events and question replay, clubs, tournaments and /me are unavailable, and nothing is saved")
+	}
+
+	bus, err := openBackplane(db)
+	if err != nil {
+		log.Error("Could not open events backplane: ", err)
+		panic(fmt.Errorf("Could not open events backplane: %s", err))
+	}
+
+	s := &Server{
+		store: store,
+		db:    db,
+		hub:   melody.New(),
+		bus:   bus,
+		key:   key,
+	}
+	s.bus.Subscribe(s.deliverEvent)
+
 	e.GET("/api/v1/auth", login)
-	e.POST("/api/v1/auth", auth)
+	e.POST("/api/v1/auth", s.auth)
 
 	rolesGroup := e.Group("/api/v1/roles")
 	rolesGroup.Use(jwtConfig)
-	rolesGroup.GET("", getRoles)
+	rolesGroup.GET("", s.getRoles)
 
 	registrationGroup := e.Group("/api/v1/registration")
 	registrationGroup.Use(jwtConfig)
-	registrationGroup.POST("", updateRegistration)
-
-	e.POST("/api/v1/questions", addQuestionController)
-	e.GET("/api/v1/questions", getQuestionsController)
-	e.DELETE("/api/v1/questions/:questionID", deleteQuestionController)
-	e.GET("/api/v1/questions/:questionID", getQuestionController)
-	e.POST("/api/v1/questions/:questionID/answers", addAnswerController)
-	e.DELETE("/api/v1/questions/:questionID/answers/:answerID", deleteAnswerController)
-	e.POST("/api/v1/games", addGameController)
-	e.GET("/api/v1/games", getGamesController)
-	e.DELETE("/api/v1/games/:gameID", deleteGameController)
-	e.GET("/api/v1/games/:gameID", getGameController)
-	e.POST("/api/v1/games/:gameID/teams", addTeamController)
-	e.GET("/api/v1/games/:gameID/teams/:teamID", getTeamController)
-	e.DELETE("/api/v1/games/:gameID/teams/:teamID/answers/:answerID", deleteTeamAnswerController)
-	e.POST("/api/v1/games/:gameID/teams/:teamID/answers/:answerID", addTeamAnswerController)
-	e.POST("/api/v1/games/:gameID/start", startGameController)
-	e.POST("/api/v1/games/:gameID/finish", finishGameController)
-	e.GET("/api/v1/games/:gameID/finished", getFinishedGameController)
-	e.POST("/api/v1/games/:gameID/next", nextQuestionController)
-	e.POST("/api/v1/games/:gameID/previous", previousQuestionController)
-	e.GET("/api/v1/games/:gameID/current", getCurrentQuestionController)
-	e.GET("/api/v1/games/:gameID/home", getHomeTeamController)
-
-	m := melody.New()
-	m.HandleMessage(func(s *melody.Session, msg []byte) {
-		if strings.HasPrefix(s.Request.URL.Path, "/ws/pbe/teams") {
-			games, err := getGames()
-			if err != nil {
-				log.Error("Could not get updated games: ", err)
-				return
-			}
-			msg, _ = json.Marshal(games)
-		} else if strings.HasPrefix(s.Request.URL.Path, "/ws/pbe/game") {
-			gameID := string(msg)
-			log.Info("WS: getting current question for : ", gameID)
-			question, err := getCurrentQuestion(gameID)
-			if err != nil {
-				log.Error("Could not get the current question: ", err)
-				return
-			}
-			msg, _ = json.Marshal(question)
-		}
-		m.BroadcastFilter(msg, func(q *melody.Session) bool {
-			return q.Request.URL.Path == s.Request.URL.Path
-		})
-	})
-
-	e.GET("/ws/pbe/teams", func(c echo.Context) error {
-		m.HandleRequest(c.Response(), c.Request())
-		return nil
-	})
-
-	e.GET("/ws/pbe/game/:gameID", func(c echo.Context) error {
-		keys := make(map[string]interface{})
-		keys["gameID"] = c.Param("gameID")
-		m.HandleRequestWithKeys(c.Response(), c.Request(), keys)
-		return nil
-	})
+	registrationGroup.POST("", s.updateRegistration)
+
+	admin := requireRoles(RoleAdmin)
+	staff := requireRoles(RoleCounselor)
+	players := requireRoles(RoleCounselor, RolePathfinder)
+	everyone := requireRoles(RoleCounselor, RolePathfinder, RoleParent)
+	mysqlOnly := s.requireMySQL
+
+	questionsGroup := e.Group("/api/v1/questions")
+	questionsGroup.Use(jwtConfig)
+	questionsGroup.POST("", s.addQuestionController, admin)
+	questionsGroup.POST("/import", s.importQuestionsController, admin, mysqlOnly)
+	questionsGroup.GET("/export", s.exportQuestionsController, staff, mysqlOnly)
+	questionsGroup.GET("", s.getQuestionsController, staff)
+	questionsGroup.DELETE("/:questionID", s.deleteQuestionController, admin)
+	questionsGroup.GET("/:questionID", s.getQuestionController, staff)
+	questionsGroup.GET("/:questionID/history", s.getQuestionHistoryController, staff, mysqlOnly)
+	questionsGroup.PUT("/:questionID", s.updateQuestionController, admin)
+	questionsGroup.PATCH("/:questionID", s.patchQuestionController, admin)
+	questionsGroup.POST("/:questionID/answers", s.addAnswerController, admin)
+	questionsGroup.DELETE("/:questionID/answers/:answerID", s.deleteAnswerController, admin)
+	questionsGroup.PUT("/:questionID/answers/:answerID", s.updateAnswerController, admin)
+	questionsGroup.PATCH("/:questionID/answers/:answerID", s.patchAnswerController, admin)
+
+	gamesGroup := e.Group("/api/v1/games")
+	gamesGroup.Use(jwtConfig)
+	gamesGroup.POST("", s.addGameController, staff)
+	gamesGroup.GET("", s.getGamesController, everyone)
+	gamesGroup.DELETE("/:gameID", s.deleteGameController, staff)
+	gamesGroup.GET("/:gameID", s.getGameController, everyone)
+	gamesGroup.POST("/:gameID/teams", s.addTeamController, staff)
+	gamesGroup.GET("/:gameID/teams/:teamID", s.getTeamController, everyone)
+	gamesGroup.GET("/:gameID/teams/:teamID/members", s.getTeamMembersController, everyone)
+	gamesGroup.POST("/:gameID/teams/:teamID/members", s.addTeamMemberController, staff)
+	gamesGroup.DELETE("/:gameID/teams/:teamID/members/:userID", s.deleteTeamMemberController, staff)
+	gamesGroup.DELETE("/:gameID/teams/:teamID/answers/:answerID", s.deleteTeamAnswerController, players)
+	gamesGroup.POST("/:gameID/teams/:teamID/answers/:answerID", s.addTeamAnswerController, players)
+	gamesGroup.POST("/:gameID/teams/:teamID/lock", s.lockTeamAnswersController, players)
+	gamesGroup.POST("/:gameID/teams/:teamID/responses", s.addTeamResponseController, players, mysqlOnly)
+	gamesGroup.DELETE("/:gameID/teams/:teamID/responses/:responseID", s.deleteTeamResponseController, players, mysqlOnly)
+	gamesGroup.POST("/:gameID/teams/:teamID/answers/:answerID/appeals", s.addAppealController, players, mysqlOnly)
+	gamesGroup.GET("/:gameID/teams/:teamID/appeals", s.getTeamAppealsController, everyone, mysqlOnly)
+	gamesGroup.GET("/:gameID/appeals", s.getAppealsController, staff, mysqlOnly)
+	gamesGroup.GET("/:gameID/appeals/:appealID", s.getAppealController, everyone, mysqlOnly)
+	gamesGroup.POST("/:gameID/appeals/:appealID/accept", s.acceptAppealController, admin, mysqlOnly)
+	gamesGroup.POST("/:gameID/appeals/:appealID/reject", s.rejectAppealController, admin, mysqlOnly)
+	gamesGroup.GET("/:gameID/responses", s.getResponsesController, staff, mysqlOnly)
+	gamesGroup.PUT("/:gameID/responses/:responseID", s.gradeResponseController, staff, mysqlOnly)
+	gamesGroup.POST("/:gameID/start", s.startGameController, staff)
+	gamesGroup.POST("/:gameID/finish", s.finishGameController, staff)
+	gamesGroup.POST("/:gameID/pause", s.pauseGameController, staff)
+	gamesGroup.POST("/:gameID/resume", s.resumeGameController, staff)
+	gamesGroup.POST("/:gameID/cancel", s.cancelGameController, staff)
+	gamesGroup.GET("/:gameID/events", s.getGameEventsController, staff, mysqlOnly)
+	gamesGroup.GET("/:gameID/timer", s.getGameTimerController, everyone)
+	gamesGroup.GET("/:gameID/questions", s.getGameQuestionsController, staff, mysqlOnly)
+	gamesGroup.GET("/:gameID/questions/replay", s.replayGameQuestionsController, staff, mysqlOnly)
+	gamesGroup.GET("/:gameID/finished", s.getFinishedGameController, everyone)
+	gamesGroup.GET("/:gameID/scoreboard", s.getScoreboardController, everyone)
+	gamesGroup.PUT("/:gameID/scoring", s.updateScoringController, staff)
+	gamesGroup.PUT("/:gameID/answering", s.updateAnsweringController, staff)
+	gamesGroup.POST("/:gameID/next", s.nextQuestionController, staff)
+	gamesGroup.POST("/:gameID/previous", s.previousQuestionController, staff)
+	gamesGroup.GET("/:gameID/current", s.getCurrentQuestionController, everyone)
+	gamesGroup.GET("/:gameID/home", s.getHomeTeamController, everyone)
+
+	gamesGroup.POST("/:gameID/clubs/:clubID", s.enrollClubController, staff, mysqlOnly)
+
+	clubsGroup := e.Group("/api/v1/clubs")
+	clubsGroup.Use(jwtConfig, mysqlOnly)
+	clubsGroup.GET("", s.getClubsController, everyone)
+	clubsGroup.POST("", s.addClubController, staff)
+	clubsGroup.GET("/:clubID", s.getClubController, everyone)
+	clubsGroup.PUT("/:clubID", s.updateClubController, staff)
+	clubsGroup.DELETE("/:clubID", s.deleteClubController, staff)
+	clubsGroup.POST("/:clubID/members", s.addClubMemberController, staff)
+	clubsGroup.DELETE("/:clubID/members/:userID", s.deleteClubMemberController, staff)
+	clubsGroup.GET("/:clubID/stats", s.getClubStatsController, everyone)
+
+	tournamentsGroup := e.Group("/api/v1/tournaments")
+	tournamentsGroup.Use(jwtConfig, mysqlOnly)
+	tournamentsGroup.GET("", s.getTournamentsController, everyone)
+	tournamentsGroup.POST("", s.addTournamentController, staff)
+	tournamentsGroup.GET("/:tournamentID", s.getTournamentController, everyone)
+	tournamentsGroup.DELETE("/:tournamentID", s.deleteTournamentController, staff)
+	tournamentsGroup.POST("/:tournamentID/clubs/:clubID", s.addTournamentClubController, staff)
+	tournamentsGroup.POST("/:tournamentID/rounds", s.addTournamentRoundController, staff)
+	tournamentsGroup.GET("/:tournamentID/standings", s.getTournamentStandingsController, everyone)
+
+	meGroup := e.Group("/api/v1/me")
+	meGroup.Use(jwtConfig, mysqlOnly)
+	meGroup.GET("/teams", s.getMyTeamsController)
+	meGroup.GET("/games", s.getMyGamesController)
+
+	s.hub.HandleMessage(s.handleMessage)
+	s.hub.Upgrader.Subprotocols = []string{"access_token"}
+	e.GET("/ws/pbe/teams", s.teamsSocketController, s.wsAuth, everyone)
+	e.GET("/ws/pbe/game/:gameID", s.gameSocketController, s.wsAuth, everyone)
+
+	go s.runGameTimers(time.Second)
 
 	e.Logger.Fatal(e.Start(":9000"))
 }
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// TeamMember struct
+type TeamMember struct {
+	UserID    string `json:"userId"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+}
+
+// lookup is what the member is looked up by: their user ID or else their
+// email.
+func (member *TeamMember) lookup() string {
+	if len(member.UserID) > 0 {
+		return member.UserID
+	}
+	return member.Email
+}
+
+// MyTeam struct
+type MyTeam struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Role       string    `json:"role"`
+	GameID     string    `json:"gameId"`
+	GameName   string    `json:"gameName"`
+	GameStatus string    `json:"gameStatus"`
+	Created    time.Time `json:"created"`
+}
+
+func (s *Server) getTeamMembersController(c echo.Context) error {
+	gameID := c.Param("gameID")
+	teamID := c.Param("teamID")
+
+	members, err := s.store.TeamMembers(c.Request().Context(), gameID, teamID)
+	if err != nil {
+		log.Error("Could not get team members: ", teamID, " : ", err)
+		return c.JSON(gameErrorStatus(err), "Could not get team members: "+teamID+" : "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, members)
+}
+
+// addTeamMemberController adds a registered user to the team roster, looked up
+// by user ID or email. Members are either Pathfinders or counselors.
+func (s *Server) addTeamMemberController(c echo.Context) error {
+	gameID := c.Param("gameID")
+	teamID := c.Param("teamID")
+	member := &TeamMember{}
+	err := c.Bind(&member)
+	if err != nil {
+		log.Error("Could not parse team member: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse team member: "+err.Error())
+	}
+
+	if len(member.Role) == 0 {
+		member.Role = RolePathfinder
+	}
+	if member.Role != RolePathfinder && member.Role != RoleCounselor {
+		return c.JSON(http.StatusBadRequest, "Team members must be a "+RolePathfinder+" or a "+RoleCounselor)
+	}
+
+	err = s.store.AddTeamMember(c.Request().Context(), gameID, teamID, member)
+	if err != nil {
+		log.Error("Could not add team member: ", err)
+		return c.JSON(gameErrorStatus(err), "Could not add team member: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, member)
+}
+
+func (s *Server) deleteTeamMemberController(c echo.Context) error {
+	gameID := c.Param("gameID")
+	teamID := c.Param("teamID")
+	userID := c.Param("userID")
+
+	err := s.store.DeleteTeamMember(c.Request().Context(), gameID, teamID, userID)
+	if err != nil {
+		log.Error("Could not delete team member: ", err)
+		return c.JSON(gameErrorStatus(err), "Could not delete team member: "+err.Error())
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// getMyTeamsController lists the teams the caller is on, newest game first.
+// Each entry carries its game, so the same list answers "my games".
+func (s *Server) getMyTeamsController(c echo.Context) error {
+	conn := s.db.WithContext(c.Request().Context())
+
+	rows, err := conn.Query(`
+		select t.id, t.name, tm.role, g.id, g.name, coalesce(g.status, 'OPEN'), g.created
+		from `+teamMembers+` tm
+		inner join users u on u.id = tm.user_id
+		inner join pbe.teams t on t.id = tm.team_id
+		inner join pbe.games g on g.id = t.game_id
+		where u.email = ?
+		order by g.created desc, t.name
+	`, currentUser(c))
+	if err != nil {
+		log.Error("Could not get teams: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get teams: "+err.Error())
+	}
+	defer rows.Close()
+
+	teams := []*MyTeam{}
+	for rows.Next() {
+		var created string
+		team := &MyTeam{}
+		err = rows.Scan(&team.ID, &team.Name, &team.Role, &team.GameID, &team.GameName, &team.GameStatus, &created)
+		if err != nil {
+			log.Error("Could not get team: ", err)
+			return c.JSON(http.StatusInternalServerError, "Could not get team: "+err.Error())
+		}
+		team.Created, _ = time.Parse("2006-01-02 15:04:05", created)
+		teams = append(teams, team)
+	}
+
+	return c.JSON(http.StatusOK, teams)
+}
+
+// getMyGamesController lists the games the caller has a team in.
+func (s *Server) getMyGamesController(c echo.Context) error {
+	conn := s.db.WithContext(c.Request().Context())
+
+	rows, err := conn.Query(`
+		select distinct g.id, g.created
+		from `+teamMembers+` tm
+		inner join users u on u.id = tm.user_id
+		inner join pbe.teams t on t.id = tm.team_id
+		inner join pbe.games g on g.id = t.game_id
+		where u.email = ?
+		order by g.created desc
+	`, currentUser(c))
+	if err != nil {
+		log.Error("Could not get games: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get games: "+err.Error())
+	}
+	defer rows.Close()
+
+	gameIDs := []string{}
+	for rows.Next() {
+		var id, created string
+		err = rows.Scan(&id, &created)
+		if err != nil {
+			log.Error("Could not get game: ", err)
+			return c.JSON(http.StatusInternalServerError, "Could not get game: "+err.Error())
+		}
+		gameIDs = append(gameIDs, id)
+	}
+
+	games := []*Game{}
+	for _, id := range gameIDs {
+		game, err := getGame(conn, id)
+		if err != nil {
+			log.Error("Could not get game: ", id, " : ", err)
+			return c.JSON(http.StatusInternalServerError, "Could not get game: "+id+" : "+err.Error())
+		}
+		games = append(games, game)
+	}
+
+	return c.JSON(http.StatusOK, games)
+}
+
+// isTeamMember reports whether the user with the given email is on the team.
+// JWTs carry the user's email as user_id.
+func isTeamMember(q queryRower, teamID, email string) (bool, error) {
+	var count int
+	err := q.QueryRow(`
+		select count(*)
+		from `+teamMembers+` tm
+		inner join users u on u.id = tm.user_id
+		where tm.team_id = ? and u.email = ?
+	`, teamID, email).Scan(&count)
+	return count > 0, err
+}
+
+// canAnswerForTeam lets staff answer for any team and everyone else only for
+// the teams they are on.
+func canAnswerForTeam(c echo.Context, q queryRower, teamID string) (bool, error) {
+	if hasRole(c, RoleCounselor) {
+		return true, nil
+	}
+	return isTeamMember(q, teamID, currentUser(c))
+}
+
+// requireGameTeam fails with a *NotFoundError unless the team is in the game,
+// so a team can not be used under another game's URL.
+func requireGameTeam(q queryRower, gameID, teamID string) error {
+	var count int
+	err := q.QueryRow(`
+		select count(*) from pbe.teams where id = ? and game_id = ?
+	`, teamID, gameID).Scan(&count)
+	if err == nil && count == 0 {
+		err = &NotFoundError{"Team", teamID}
+	}
+	return err
+}
+
+// answersForTeam is canAnswerForTeam checked against the store.
+func (s *Server) answersForTeam(c echo.Context, teamID string) (bool, error) {
+	if hasRole(c, RoleCounselor) {
+		return true, nil
+	}
+	return s.store.TeamMember(c.Request().Context(), teamID, currentUser(c))
+}
+
+// userTeam returns the user's team in the game, or "" if they are not on one.
+func userTeam(q queryRower, gameID, email string) (string, error) {
+	var teamID string
+	err := q.QueryRow(`
+		select t.id
+		from `+teamMembers+` tm
+		inner join users u on u.id = tm.user_id
+		inner join pbe.teams t on t.id = tm.team_id
+		where t.game_id = ? and u.email = ?
+		order by t.name
+		limit 1
+	`, gameID, email).Scan(&teamID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return teamID, err
+}
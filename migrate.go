@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/labstack/gommon/log"
+)
+
+// migration is one version of the schema. The driver runs one statement per
+// query, so up and down are lists of statements. MySQL commits schema changes
+// as it goes, so a migration that fails part way is not undone and must be
+// fixed by hand.
+type migration struct {
+	version int
+	name    string
+	up      []string
+	down    []string
+}
+
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// schemaVersion returns the newest migration applied to the database, or 0
+// if none has been.
+func schemaVersion(conn *Conn) (int, error) {
+	var version int
+	err := conn.QueryRow(`
+		select coalesce(max(version), 0) from schema_migrations
+	`).Scan(&version)
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1146 {
+		return 0, nil
+	}
+	return version, err
+}
+
+// checkSchema refuses to run against a database that is missing migrations
+// this build needs.
+func checkSchema(conn *Conn) error {
+	version, err := schemaVersion(conn)
+	if err != nil {
+		return err
+	}
+	latest := latestSchemaVersion()
+	if version < latest {
+		return fmt.Errorf("The database schema is at version %d but this build needs version %d. Run the migrate command first", version, latest)
+	}
+	if version > latest {
+		log.Warn("The database schema is at version ", version, ", newer than this build knows about (", latest, ")")
+	}
+	return nil
+}
+
+// migrateUp applies the migrations after the database's version, up to and
+// including target.
+func migrateUp(conn *Conn, target int) error {
+	_, err := conn.Exec(`
+		create table if not exists schema_migrations (
+			version int primary key,
+			name varchar(100) not null,
+			applied datetime not null
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("Could not create schema_migrations: %v", err)
+	}
+
+	version, err := schemaVersion(conn)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= version || m.version > target {
+			continue
+		}
+		log.Info("Applying migration ", m.version, " ", m.name)
+		for _, statement := range m.up {
+			_, err = conn.Exec(statement)
+			if err != nil {
+				return fmt.Errorf("Could not apply migration %d %s: %v", m.version, m.name, err)
+			}
+		}
+		_, err = conn.Exec(`
+			insert into schema_migrations(version, name, applied)
+			values(?,?,NOW())
+		`, m.version, m.name)
+		if err != nil {
+			return fmt.Errorf("Could not record migration %d %s: %v", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+// migrateDown reverts the applied migrations newer than target, newest first.
+func migrateDown(conn *Conn, target int) error {
+	version, err := schemaVersion(conn)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version > version || m.version <= target {
+			continue
+		}
+		log.Info("Reverting migration ", m.version, " ", m.name)
+		for _, statement := range m.down {
+			_, err = conn.Exec(statement)
+			if err != nil {
+				return fmt.Errorf("Could not revert migration %d %s: %v", m.version, m.name, err)
+			}
+		}
+		_, err = conn.Exec(`
+			delete from schema_migrations where version = ?
+		`, m.version)
+		if err != nil {
+			return fmt.Errorf("Could not record migration %d %s: %v", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+// migrateCommand runs the migrate subcommand:
+//
+//	migrate [up [version]]   apply migrations, by default all of them
+//	migrate down [version]   revert migrations, by default the newest one
+//	migrate status           print the database and build versions
+func migrateCommand(conn *Conn, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	version, err := schemaVersion(conn)
+	if err != nil {
+		return err
+	}
+
+	target := -1
+	if len(args) > 1 {
+		target, err = strconv.Atoi(args[1])
+		if err != nil || target < 0 {
+			return fmt.Errorf("Version must be a positive number: %s", args[1])
+		}
+	}
+
+	switch command {
+	case "up":
+		if target < 0 {
+			target = latestSchemaVersion()
+		}
+		err = migrateUp(conn, target)
+	case "down":
+		if target < 0 {
+			target = 0
+			for _, m := range migrations {
+				if m.version < version {
+					target = m.version
+				}
+			}
+		}
+		err = migrateDown(conn, target)
+	case "status":
+	default:
+		return fmt.Errorf("Unknown migrate command: %s", command)
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err = schemaVersion(conn)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Database schema version %d, latest version %d\n", version, latestSchemaVersion())
+	return nil
+}
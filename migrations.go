@@ -0,0 +1,639 @@
+package main
+
+// migrations is the schema, oldest first. Versions must increase and must
+// never be reused once released; change the schema by adding a migration.
+// The first two create the original rocketeers.sql and pbe.sql and do
+// nothing to tables that already exist, so databases made from those scripts
+// can adopt them; every later change is its own migration.
+var migrations = []*migration{
+	{
+		version: 1,
+		name:    "users",
+		up: []string{
+			`
+				create table if not exists rocketeers.roles (
+					id varchar(20) primary key
+				)
+			`,
+			`
+				create table if not exists rocketeers.users (
+					id varchar(50) primary key,
+					first_name varchar(50) not null,
+					last_name varchar(50) not null,
+					birthdate date,
+					gender varchar(10),
+					email varchar(255) unique,
+					phone varchar(20),
+					carrier varchar(50),
+					image_url varchar(255)
+				)
+			`,
+			`
+				create table if not exists rocketeers.user_roles (
+					user_id varchar(50) not null,
+					role_id varchar(20) not null,
+					primary key (user_id, role_id),
+					index user_roles_user_idx (user_id),
+					index user_roles_role_idx (role_id),
+					foreign key (user_id)
+					references rocketeers.users(id)
+					on delete cascade,
+					foreign key (role_id)
+					references rocketeers.roles(id)
+					on delete cascade
+				)
+			`,
+			`
+				create table if not exists rocketeers.images (
+					id varchar(50) primary key,
+					image blob not null,
+					content_type varchar(20) not null
+				)
+			`,
+			`
+				create table if not exists rocketeers.user_images (
+					user_id varchar(50) not null,
+					image_id varchar(50) not null,
+					primary key (user_id, image_id),
+					index user_images_user_idx (user_id),
+					index user_images_image_idx (image_id),
+					foreign key (user_id)
+					references rocketeers.users(id)
+					on delete cascade,
+					foreign key (image_id)
+					references rocketeers.images(id)
+					on delete cascade
+				)
+			`,
+			`
+				insert ignore into rocketeers.roles(id) values('ADMIN'), ('PATHFINDER'), ('COUNSELOR'), ('PARENT')
+			`,
+		},
+		down: []string{
+			`drop table if exists rocketeers.user_images`,
+			`drop table if exists rocketeers.images`,
+			`drop table if exists rocketeers.user_roles`,
+			`drop table if exists rocketeers.users`,
+			`drop table if exists rocketeers.roles`,
+		},
+	},
+	{
+		version: 2,
+		name:    "pbe",
+		up: []string{
+			`
+				create database if not exists pbe
+			`,
+			`
+				create table if not exists pbe.questions (
+					id varchar(50) primary key,
+					book varchar(50) not null,
+					chapter varchar(50) not null,
+					verses varchar(50) not null,
+					question varchar(500) not null
+				)
+			`,
+			`
+				create table if not exists pbe.answers (
+					id varchar(50) primary key,
+					answer varchar(500) not null,
+					status bit default 0,
+					question_id varchar(50) not null,
+					index answers_questions_idx (question_id),
+					foreign key (question_id)
+					references pbe.questions(id)
+					on delete cascade
+				)
+			`,
+			`
+				create table if not exists pbe.games (
+					id varchar(50) primary key,
+					name varchar(50) not null,
+					seconds int not null,
+					created datetime not null,
+					questions int not null,
+					status varchar(50),
+					question varchar(50)
+				)
+			`,
+			`
+				create table if not exists pbe.game_chapters (
+					id varchar(50) primary key,
+					book varchar(50) not null,
+					chapter varchar(50),
+					game_id varchar(50),
+					index game_chapters_games_idx(game_id),
+					foreign key (game_id)
+					references pbe.games(id)
+					on delete cascade
+				)
+			`,
+			`
+				create table if not exists pbe.game_questions (
+					id varchar(50) primary key,
+					game_id varchar(50),
+					question_id varchar(50) not null,
+					position smallint,
+					index game_chapters_games_idx(game_id),
+					index game_questions_questions_idx(question_id),
+					foreign key (game_id)
+					references pbe.games(id)
+					on delete cascade,
+					foreign key (question_id)
+					references pbe.questions(id)
+					on delete cascade
+				)
+			`,
+			`
+				create table if not exists pbe.teams (
+					id varchar(50) primary key,
+					name varchar(50) not null,
+					game_id varchar(50) not null,
+					index teams_games_idx(game_id),
+					foreign key (game_id)
+					references pbe.games(id)
+					on delete cascade
+				)
+			`,
+			`
+				create table if not exists pbe.team_answers (
+					id varchar(50) primary key,
+					game_id varchar(50) not null,
+					team_id varchar(50) not null,
+					answer_id varchar(50) not null,
+					created datetime not null,
+					index team_answers_games_idx(game_id),
+					index team_answers_teams_idx(team_id),
+					index team_answers_answers_idx(answer_id),
+					foreign key (game_id)
+					references pbe.games(id)
+					on delete cascade,
+					foreign key (team_id)
+					references pbe.teams(id)
+					on delete cascade,
+					foreign key (answer_id)
+					references pbe.answers(id)
+					on delete cascade
+				)
+			`,
+		},
+		down: []string{
+			`drop table if exists pbe.team_answers`,
+			`drop table if exists pbe.teams`,
+			`drop table if exists pbe.game_questions`,
+			`drop table if exists pbe.game_chapters`,
+			`drop table if exists pbe.games`,
+			`drop table if exists pbe.answers`,
+			`drop table if exists pbe.questions`,
+			`drop database if exists pbe`,
+		},
+	},
+	{
+		version: 3,
+		name:    "game_events",
+		up: []string{
+			`
+				create table pbe.game_events (
+					id varchar(50) primary key,
+					game_id varchar(50) not null,
+					from_status varchar(50) not null,
+					to_status varchar(50) not null,
+					user_id varchar(255),
+					created datetime not null,
+					index game_events_games_idx(game_id),
+					foreign key (game_id)
+					references pbe.games(id)
+					on delete cascade
+				)
+			`,
+		},
+		down: []string{
+			`drop table pbe.game_events`,
+		},
+	},
+	{
+		version: 4,
+		name:    "question_timer",
+		up: []string{
+			`
+				alter table pbe.games
+					add column auto_advance bit not null default 0,
+					add column reject_late bit not null default 0,
+					add column paused datetime
+			`,
+			`
+				alter table pbe.game_questions
+					add column started datetime
+			`,
+			`
+				alter table pbe.team_answers
+					add column late bit not null default 0
+			`,
+		},
+		down: []string{
+			`alter table pbe.team_answers drop column late`,
+			`alter table pbe.game_questions drop column started`,
+			`alter table pbe.games drop column paused, drop column reject_late, drop column auto_advance`,
+		},
+	},
+	{
+		version: 5,
+		name:    "game_seed",
+		up: []string{
+			`
+				alter table pbe.games
+					add column seed bigint not null default 0
+			`,
+		},
+		down: []string{
+			`alter table pbe.games drop column seed`,
+		},
+	},
+	{
+		version: 6,
+		name:    "game_scoring",
+		up: []string{
+			`
+				alter table pbe.games
+					add column scoring varchar(1000)
+			`,
+		},
+		down: []string{
+			`alter table pbe.games drop column scoring`,
+		},
+	},
+	{
+		version: 7,
+		name:    "team_members",
+		up: []string{
+			`
+				create table pbe.team_members (
+					team_id varchar(50) not null,
+					user_id varchar(50) not null,
+					role varchar(20) not null,
+					primary key (team_id, user_id),
+					index team_members_users_idx(user_id),
+					foreign key (team_id)
+					references pbe.teams(id)
+					on delete cascade,
+					foreign key (user_id)
+					references rocketeers.users(id)
+					on delete cascade
+				)
+			`,
+		},
+		down: []string{
+			`drop table pbe.team_members`,
+		},
+	},
+	{
+		version: 8,
+		name:    "clubs",
+		up: []string{
+			`
+				create table pbe.clubs (
+					id varchar(50) primary key,
+					name varchar(50) not null,
+					division varchar(50),
+					created datetime not null
+				)
+			`,
+			`
+				create table pbe.club_members (
+					club_id varchar(50) not null,
+					user_id varchar(50) not null,
+					role varchar(20) not null,
+					primary key (club_id, user_id),
+					index club_members_users_idx(user_id),
+					foreign key (club_id)
+					references pbe.clubs(id)
+					on delete cascade,
+					foreign key (user_id)
+					references rocketeers.users(id)
+					on delete cascade
+				)
+			`,
+			`
+				alter table pbe.teams
+					add column club_id varchar(50),
+					add index teams_clubs_idx(club_id),
+					add constraint teams_clubs_fk foreign key (club_id)
+					references pbe.clubs(id)
+					on delete set null
+			`,
+		},
+		down: []string{
+			`alter table pbe.teams drop foreign key teams_clubs_fk`,
+			`alter table pbe.teams drop column club_id`,
+			`drop table pbe.club_members`,
+			`drop table pbe.clubs`,
+		},
+	},
+	{
+		version: 9,
+		name:    "tournaments",
+		up: []string{
+			`
+				create table pbe.tournaments (
+					id varchar(50) primary key,
+					name varchar(50) not null,
+					format varchar(20) not null,
+					status varchar(50) not null,
+					created datetime not null
+				)
+			`,
+			`
+				create table pbe.tournament_clubs (
+					tournament_id varchar(50) not null,
+					club_id varchar(50) not null,
+					seed int not null,
+					primary key (tournament_id, club_id),
+					index tournament_clubs_clubs_idx(club_id),
+					foreign key (tournament_id)
+					references pbe.tournaments(id)
+					on delete cascade,
+					foreign key (club_id)
+					references pbe.clubs(id)
+					on delete cascade
+				)
+			`,
+			`
+				create table pbe.tournament_rounds (
+					id varchar(50) primary key,
+					tournament_id varchar(50) not null,
+					number int not null,
+					created datetime not null,
+					unique key tournament_rounds_number_idx(tournament_id, number),
+					foreign key (tournament_id)
+					references pbe.tournaments(id)
+					on delete cascade
+				)
+			`,
+			`
+				create table pbe.tournament_matches (
+					id varchar(50) primary key,
+					round_id varchar(50) not null,
+					position int not null,
+					game_id varchar(50),
+					bye_club_id varchar(50),
+					index tournament_matches_rounds_idx(round_id),
+					index tournament_matches_games_idx(game_id),
+					foreign key (round_id)
+					references pbe.tournament_rounds(id)
+					on delete cascade,
+					foreign key (game_id)
+					references pbe.games(id)
+					on delete cascade,
+					foreign key (bye_club_id)
+					references pbe.clubs(id)
+					on delete cascade
+				)
+			`,
+		},
+		down: []string{
+			`drop table pbe.tournament_matches`,
+			`drop table pbe.tournament_rounds`,
+			`drop table pbe.tournament_clubs`,
+			`drop table pbe.tournaments`,
+		},
+	},
+	{
+		version: 10,
+		name:    "answer_rules",
+		up: []string{
+			`
+				alter table pbe.games
+					add column answering varchar(255)
+			`,
+		},
+		down: []string{
+			`alter table pbe.games drop column answering`,
+		},
+	},
+	{
+		// Earlier servers let a team select the same answer twice. The first
+		// selection is kept, so the delete can run again if the key fails.
+		version: 11,
+		name:    "team_answers_unique",
+		up: []string{
+			`
+				delete ta
+				from pbe.team_answers ta
+				inner join pbe.team_answers kept
+					on kept.game_id = ta.game_id
+					and kept.team_id = ta.team_id
+					and kept.answer_id = ta.answer_id
+					and (kept.created < ta.created or (kept.created = ta.created and kept.id < ta.id))
+			`,
+			`
+				alter table pbe.team_answers
+					add unique key team_answers_unique_idx(game_id, team_id, answer_id)
+			`,
+		},
+		down: []string{
+			`alter table pbe.team_answers drop index team_answers_unique_idx`,
+		},
+	},
+	{
+		version: 12,
+		name:    "team_answer_questions",
+		up: []string{
+			`
+				alter table pbe.team_answers
+					add column game_question_id varchar(50) after game_id,
+					add index team_answers_game_questions_idx(game_question_id),
+					add constraint team_answers_game_questions_fk foreign key (game_question_id)
+					references pbe.game_questions(id)
+					on delete cascade
+			`,
+			// Existing answers get the game question they were given for,
+			// preferring the latest one asked before the answer.
+			`
+				update pbe.team_answers ta
+				inner join pbe.answers a on a.id = ta.answer_id
+				set ta.game_question_id = (
+					select gq.id from pbe.game_questions gq
+					where gq.game_id = ta.game_id and gq.question_id = a.question_id
+					order by gq.started <= ta.created desc, gq.started desc
+					limit 1
+				)
+				where ta.game_question_id is null
+			`,
+		},
+		down: []string{
+			`alter table pbe.team_answers drop foreign key team_answers_game_questions_fk`,
+			`alter table pbe.team_answers drop column game_question_id`,
+		},
+	},
+	{
+		version: 13,
+		name:    "team_responses",
+		up: []string{
+			`
+				create table pbe.team_responses (
+					id varchar(50) primary key,
+					game_id varchar(50) not null,
+					game_question_id varchar(50) not null,
+					team_id varchar(50) not null,
+					response varchar(500) not null,
+					normalized varchar(500) not null,
+					answer_id varchar(50),
+					status varchar(20) not null,
+					matched_by varchar(20),
+					judge_id varchar(255),
+					late bit not null default 0,
+					created datetime not null,
+					graded datetime,
+					unique key team_responses_unique_idx(game_question_id, team_id, normalized),
+					index team_responses_games_idx(game_id, status),
+					index team_responses_teams_idx(team_id),
+					foreign key (game_id)
+					references pbe.games(id)
+					on delete cascade,
+					foreign key (game_question_id)
+					references pbe.game_questions(id)
+					on delete cascade,
+					foreign key (team_id)
+					references pbe.teams(id)
+					on delete cascade,
+					foreign key (answer_id)
+					references pbe.answers(id)
+					on delete set null
+				)
+			`,
+		},
+		down: []string{
+			`drop table pbe.team_responses`,
+		},
+	},
+	{
+		version: 14,
+		name:    "appeals",
+		up: []string{
+			`
+				alter table pbe.team_answers
+					add column ruling bit
+			`,
+			`
+				create table pbe.appeals (
+					id varchar(50) primary key,
+					game_id varchar(50) not null,
+					team_id varchar(50) not null,
+					team_answer_id varchar(50) not null,
+					reason varchar(1000) not null,
+					status varchar(20) not null,
+					filed_by varchar(255),
+					created datetime not null,
+					index appeals_games_idx(game_id, status),
+					index appeals_team_answers_idx(team_answer_id),
+					foreign key (game_id)
+					references pbe.games(id)
+					on delete cascade,
+					foreign key (team_id)
+					references pbe.teams(id)
+					on delete cascade,
+					foreign key (team_answer_id)
+					references pbe.team_answers(id)
+					on delete cascade
+				)
+			`,
+			`
+				create table pbe.appeal_events (
+					id varchar(50) primary key,
+					appeal_id varchar(50) not null,
+					from_status varchar(20) not null,
+					to_status varchar(20) not null,
+					user_id varchar(255),
+					note varchar(1000),
+					created datetime not null,
+					index appeal_events_appeals_idx(appeal_id),
+					foreign key (appeal_id)
+					references pbe.appeals(id)
+					on delete cascade
+				)
+			`,
+		},
+		down: []string{
+			`drop table pbe.appeal_events`,
+			`drop table pbe.appeals`,
+			`alter table pbe.team_answers drop column ruling`,
+		},
+	},
+	{
+		version: 15,
+		name:    "broadcasts",
+		up: []string{
+			`
+				create table pbe.broadcasts (
+					id bigint auto_increment primary key,
+					instance varchar(50) not null,
+					event mediumtext not null,
+					created datetime not null,
+					index broadcasts_created_idx(created)
+				)
+			`,
+		},
+		down: []string{
+			`drop table pbe.broadcasts`,
+		},
+	},
+	{
+		version: 16,
+		name:    "game_event_order",
+		up: []string{
+			`
+				alter table pbe.game_events
+					add column seq bigint not null auto_increment,
+					add unique key game_events_seq_idx(seq)
+			`,
+		},
+		down: []string{
+			`alter table pbe.game_events drop column seq`,
+		},
+	},
+	{
+		version: 17,
+		name:    "team_locks",
+		up: []string{
+			`
+				create table pbe.team_locks (
+					game_question_id varchar(50) not null,
+					team_id varchar(50) not null,
+					user_id varchar(255),
+					created datetime not null,
+					primary key (game_question_id, team_id),
+					index team_locks_teams_idx(team_id),
+					foreign key (game_question_id)
+					references pbe.game_questions(id)
+					on delete cascade,
+					foreign key (team_id)
+					references pbe.teams(id)
+					on delete cascade
+				)
+			`,
+		},
+		down: []string{
+			`drop table pbe.team_locks`,
+		},
+	},
+	{
+		version: 18,
+		name:    "appeal_order",
+		up: []string{
+			`
+				alter table pbe.appeals
+					add column seq bigint not null auto_increment,
+					add unique key appeals_seq_idx(seq)
+			`,
+			`
+				alter table pbe.appeal_events
+					add column seq bigint not null auto_increment,
+					add unique key appeal_events_seq_idx(seq)
+			`,
+		},
+		down: []string{
+			`alter table pbe.appeal_events drop column seq`,
+			`alter table pbe.appeals drop column seq`,
+		},
+	},
+}
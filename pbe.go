@@ -2,13 +2,14 @@ package main
 
 import (
 	"database/sql"
-	"math/rand"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/gommon/log"
-	"github.com/spf13/viper"
 )
 
 // Question struct
@@ -29,19 +30,26 @@ type Answer struct {
 	Status       bool   `json:"status"`
 	TeamAnswerID string `json:"team_answer_id"`
 	Checked      bool   `json:"checked"`
+	Late         bool   `json:"late"`
 }
 
 // Game struct
 type Game struct {
-	ID         string         `json:"id"`
-	Name       string         `json:"name"`
-	Seconds    int            `json:"seconds"`
-	Questions  int            `json:"questions"`
-	Questions2 []*Question    `json:"questions2"`
-	Status     string         `json:"status"`
-	Created    time.Time      `json:"created"`
-	Chapters   []*GameChapter `json:"chapters"`
-	Teams      []*Team        `json:"teams"`
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Seconds     int            `json:"seconds"`
+	Questions   int            `json:"questions"`
+	Questions2  []*Question    `json:"questions2"`
+	Status      string         `json:"status"`
+	Created     time.Time      `json:"created"`
+	Chapters    []*GameChapter `json:"chapters"`
+	Teams       []*Team        `json:"teams"`
+	AutoAdvance bool           `json:"autoAdvance"`
+	RejectLate  bool           `json:"rejectLate"`
+	Seed        int64          `json:"seed"`
+	Scoring     *ScoringRules  `json:"scoring"`
+	Answering   *AnswerRules   `json:"answering"`
+	ClubIDs     []string       `json:"clubIds"`
 }
 
 // GameChapter struct
@@ -53,19 +61,145 @@ type GameChapter struct {
 
 // Team struct
 type Team struct {
-	ID      string    `json:"id"`
-	Name    string    `json:"name"`
-	Answers []*Answer `json:"answers"`
-	Points  int       `json:"points"`
+	ID      string           `json:"id"`
+	Name    string           `json:"name"`
+	ClubID  string           `json:"clubId"`
+	Answers []*Answer        `json:"answers"`
+	Points  float64          `json:"points"`
+	Scores  []*QuestionScore `json:"scores"`
 }
 
-func getQuestionsController(c echo.Context) error {
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
+// QuestionFilter struct
+type QuestionFilter struct {
+	Book      string
+	Chapter   string
+	VerseFrom int
+	VerseTo   int
+	Search    string
+	Offset    int
+	Limit     int
+}
+
+const (
+	defaultQuestionsPageSize = 50
+	maxQuestionsPageSize     = 500
+)
+
+func (s *Server) getQuestionsController(c echo.Context) error {
+	filter, err := questionFilterFromQuery(c)
+	if err != nil {
+		log.Error("Could not parse question filter: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse question filter: "+err.Error())
+	}
+
+	questions, total, err := s.store.Questions(c.Request().Context(), filter)
+	if err != nil {
+		log.Error("Could not get questions: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get questions: "+err.Error())
+	}
+
+	c.Response().Header().Set("X-Total-Count", strconv.Itoa(total))
+	c.Response().Header().Set("X-Offset", strconv.Itoa(filter.Offset))
+	c.Response().Header().Set("X-Limit", strconv.Itoa(filter.Limit))
+	return c.JSON(http.StatusOK, questions)
+}
+
+// questionFilterFromQuery reads book, chapter, verseFrom, verseTo, q, offset
+// and limit from the query string. A missing or zero limit returns the default
+// page size, and no page is larger than maxQuestionsPageSize.
+func questionFilterFromQuery(c echo.Context) (*QuestionFilter, error) {
+	filter := &QuestionFilter{
+		Book:    strings.TrimSpace(c.QueryParam("book")),
+		Chapter: strings.TrimSpace(c.QueryParam("chapter")),
+		Search:  strings.TrimSpace(c.QueryParam("q")),
+	}
+
+	params := []struct {
+		name  string
+		value *int
+	}{
+		{"verseFrom", &filter.VerseFrom},
+		{"verseTo", &filter.VerseTo},
+		{"offset", &filter.Offset},
+		{"limit", &filter.Limit},
+	}
+	for _, param := range params {
+		value := c.QueryParam(param.name)
+		if len(value) == 0 {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%s must be a non-negative number", param.name)
+		}
+		*param.value = n
+	}
+
+	if filter.Limit == 0 {
+		filter.Limit = defaultQuestionsPageSize
+	}
+	if filter.Limit > maxQuestionsPageSize {
+		filter.Limit = maxQuestionsPageSize
+	}
+	if filter.VerseTo > 0 && filter.VerseFrom > filter.VerseTo {
+		return nil, fmt.Errorf("verseFrom must not be greater than verseTo")
+	}
+	return filter, nil
+}
+
+// where returns the conditions and arguments shared by the count and page
+// queries. Verses are stored as text such as "3" or "3-5", so the range
+// filter compares the first and last verse numbers.
+func (filter *QuestionFilter) where() (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	args := []interface{}{}
+	if len(filter.Book) > 0 {
+		conditions = append(conditions, "q.book = ?")
+		args = append(args, filter.Book)
+	}
+	if len(filter.Chapter) > 0 {
+		conditions = append(conditions, "q.chapter = ?")
+		args = append(args, filter.Chapter)
+	}
+	if filter.VerseFrom > 0 {
+		conditions = append(conditions, "cast(substring_index(q.verses, '-', -1) as unsigned) >= ?")
+		args = append(args, filter.VerseFrom)
+	}
+	if filter.VerseTo > 0 {
+		conditions = append(conditions, "cast(substring_index(q.verses, '-', 1) as unsigned) <= ?")
+		args = append(args, filter.VerseTo)
+	}
+	if len(filter.Search) > 0 {
+		like := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(filter.Search) + "%"
+		conditions = append(conditions, `(q.question like ? or exists (
+			select 1 from pbe.answers sa where sa.question_id = q.id and sa.answer like ?
+		))`)
+		args = append(args, like, like)
+	}
+	return strings.Join(conditions, " and "), args
+}
+
+// getQuestions returns one page of the question bank with its answers and the
+// total number of questions matching the filter.
+func getQuestions(conn *Conn, filter *QuestionFilter) ([]*Question, int, error) {
+	where, args := filter.where()
+
+	var total int
+	err := conn.QueryRow(`
+		select count(*) from pbe.questions q where `+where, args...).Scan(&total)
 	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
+		log.Error("Could not count questions: ", err)
+		return nil, 0, err
+	}
+
+	page := ""
+	if filter.Limit > 0 {
+		page = "limit ? offset ?"
+		args = append(args, filter.Limit, filter.Offset)
+	} else if filter.Offset > 0 {
+		page = "limit 18446744073709551615 offset ?"
+		args = append(args, filter.Offset)
 	}
-	defer conn.Close()
 
 	rows, err := conn.Query(`
 		select
@@ -77,14 +211,22 @@ func getQuestionsController(c echo.Context) error {
 			, COALESCE(a.id, '')
 			, COALESCE(a.answer, '')
 			, COALESCE(a.status, false)
-		from pbe.questions q
+		from (
+			select q.id, q.book, q.chapter, q.verses
+			from pbe.questions q
+			where `+where+`
+			order by q.book, q.chapter, q.verses, q.id
+			`+page+`
+		) p
+		inner join pbe.questions q on q.id = p.id
 		left join pbe.answers a on a.question_id = q.id
 		order by q.book, q.chapter, q.verses, q.id, a.answer
-	`)
+	`, args...)
 	if err != nil {
 		log.Error("Could not get question: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not get question: "+err.Error())
+		return nil, 0, err
 	}
+	defer rows.Close()
 
 	questions := []*Question{}
 	question := &Question{}
@@ -102,7 +244,7 @@ func getQuestionsController(c echo.Context) error {
 		err = rows.Scan(&id, &book, &chapter, &verses, &questionText, &answerID, &answer, &status)
 		if err != nil {
 			log.Error("Could not get question: ", err)
-			return c.JSON(http.StatusInternalServerError, "Could not get question: "+err.Error())
+			return nil, 0, err
 		}
 
 		if question.ID != id {
@@ -125,10 +267,10 @@ func getQuestionsController(c echo.Context) error {
 		}
 	}
 
-	return c.JSON(http.StatusOK, questions)
+	return questions, total, nil
 }
 
-func addQuestionController(c echo.Context) error {
+func (s *Server) addQuestionController(c echo.Context) error {
 	question := &Question{}
 	err := c.Bind(&question)
 	if err != nil {
@@ -136,58 +278,19 @@ func addQuestionController(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, "Could not parse question: "+err.Error())
 	}
 
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
-	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
-	}
-	defer conn.Close()
-
-	tx, err := conn.Begin()
+	err = s.store.AddQuestion(c.Request().Context(), question)
 	if err != nil {
-		log.Error("Could not create database transaction: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not create database transaction: "+err.Error())
-	}
-
-	question.ID, _ = UUID()
-	_, err = tx.Exec(`
-		insert into pbe.questions(id, book, chapter, verses, question) values(?,?,?,?,?)
-	`, question.ID, question.Book, question.Chapter, question.Verses, question.Question)
-	if err != nil {
-		log.Error("Could not create question: ", err)
-		tx.Rollback()
-		return c.JSON(http.StatusInternalServerError, "Could not create question: "+err.Error())
-	}
-
-	for _, answer := range question.Answers {
-		answer.ID, _ = UUID()
-		_, err = tx.Exec(`
-			insert into pbe.answers(id, answer, status, question_id)
-			values(?,?,?,?)
-		`, answer.ID, answer.Answer, answer.Status, question.ID)
-		if err != nil {
-			log.Error("Could not create answer: ", err)
-			tx.Rollback()
-			return c.JSON(http.StatusInternalServerError, "Coul dnot create answer: "+err.Error())
-		}
+		log.Error(err)
+		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
-	tx.Commit()
 	return c.JSON(http.StatusOK, question)
 }
 
-func deleteQuestionController(c echo.Context) error {
+func (s *Server) deleteQuestionController(c echo.Context) error {
 	questionID := c.Param("questionID")
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
-	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
-	}
-	defer conn.Close()
 
-	_, err = conn.Exec(`
-		delete from pbe.questions where id = ?
-	`, questionID)
+	err := s.store.DeleteQuestion(c.Request().Context(), questionID)
 	if err != nil {
 		log.Error("Could not delete question: ", questionID, " : ", err)
 		return c.JSON(http.StatusInternalServerError, "Could not delete question: "+questionID+" : "+err.Error())
@@ -196,18 +299,10 @@ func deleteQuestionController(c echo.Context) error {
 	return c.NoContent(http.StatusOK)
 }
 
-func deleteAnswerController(c echo.Context) error {
+func (s *Server) deleteAnswerController(c echo.Context) error {
 	answerID := c.Param("answerID")
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
-	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
-	}
-	defer conn.Close()
 
-	_, err = conn.Exec(`
-		delete from pbe.answers where id = ?
-	`, answerID)
+	err := s.store.DeleteAnswer(c.Request().Context(), answerID)
 	if err != nil {
 		log.Error("Could not delete answer: ", answerID, " : ", err)
 		return c.JSON(http.StatusInternalServerError, "Could not delete answer: "+answerID+" : "+err.Error())
@@ -216,26 +311,19 @@ func deleteAnswerController(c echo.Context) error {
 	return c.NoContent(http.StatusOK)
 }
 
-func getQuestionController(c echo.Context) error {
+func (s *Server) getQuestionController(c echo.Context) error {
 	questionID := c.Param("questionID")
 
-	question, err := getQuestion(questionID)
+	question, err := s.store.Question(c.Request().Context(), questionID)
 	if err != nil {
 		log.Error("Could not get question: ", questionID, " : ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not get question: "+questionID+" : "+err.Error())
+		return c.JSON(gameErrorStatus(err), "Could not get question: "+questionID+" : "+err.Error())
 	}
 
 	return c.JSON(http.StatusOK, question)
 }
 
-func getQuestion(questionID string) (*Question, error) {
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
-	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return nil, err
-	}
-	defer conn.Close()
-
+func getQuestion(conn *Conn, questionID string) (*Question, error) {
 	rows, err := conn.Query(`
 		select 
 			q.book
@@ -292,7 +380,7 @@ func getQuestion(questionID string) (*Question, error) {
 	return question, nil
 }
 
-func addAnswerController(c echo.Context) error {
+func (s *Server) addAnswerController(c echo.Context) error {
 	questionID := c.Param("questionID")
 	answer := &Answer{}
 	err := c.Bind(&answer)
@@ -301,69 +389,401 @@ func addAnswerController(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, "Could not parse answer: "+err.Error())
 	}
 
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
+	err = s.store.AddAnswer(c.Request().Context(), questionID, answer)
 	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
+		log.Error("Could not create answer: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not create answer: "+err.Error())
 	}
-	defer conn.Close()
 
-	answer.ID, _ = UUID()
+	return c.JSON(http.StatusOK, answer)
+}
+
+// QuestionPatch struct
+type QuestionPatch struct {
+	Book     *string `json:"book"`
+	Chapter  *string `json:"chapter"`
+	Verses   *string `json:"verses"`
+	Question *string `json:"question"`
+}
+
+// AnswerPatch struct
+type AnswerPatch struct {
+	Answer *string `json:"answer"`
+	Status *bool   `json:"status"`
+}
+
+// NotFoundError is returned when the question or answer being changed does
+// not exist.
+type NotFoundError struct {
+	What string
+	ID   string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.What + " not found: " + e.ID
+}
+
+// questionPatchField is one field of a QuestionPatch with its column and
+// length limit.
+type questionPatchField struct {
+	name   string
+	column string
+	value  *string
+	max    int
+}
 
-	_, err = conn.Exec(`
-		insert into pbe.answers(id, answer, status, question_id)
-		values(?,?,?,?)
-	`, answer.ID, answer.Answer, answer.Status, questionID)
+func (patch *QuestionPatch) fields() []questionPatchField {
+	return []questionPatchField{
+		{"book", "book", patch.Book, 50},
+		{"chapter", "chapter", patch.Chapter, 50},
+		{"verses", "verses", patch.Verses, 50},
+		{"question", "question", patch.Question, 500},
+	}
+}
+
+// updateQuestionController replaces the question text and updates the listed
+// answers in place. Answers without an ID are added. Answers that are left out
+// are kept, so team answers from past games still point at them.
+func (s *Server) updateQuestionController(c echo.Context) error {
+	questionID := c.Param("questionID")
+	question := &Question{}
+	err := c.Bind(&question)
 	if err != nil {
-		log.Error("Could not create answer: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not create answer: "+err.Error())
+		log.Error("Could not parse question: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse question: "+err.Error())
+	}
+	question.ID = questionID
+
+	errs := validateQuestionFields(question)
+	for i, answer := range question.Answers {
+		errs = append(errs, validateAnswer(i, answer)...)
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, errs)
+	}
+
+	err = s.store.UpdateQuestion(c.Request().Context(), question)
+	if _, ok := err.(*NotFoundError); ok {
+		return c.JSON(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		log.Error("Could not update question: ", questionID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not update question: "+questionID+" : "+err.Error())
+	}
+
+	return s.getQuestionController(c)
+}
+
+// updateQuestion saves the question and its answers for
+// updateQuestionController.
+func updateQuestion(tx *sql.Tx, question *Question) error {
+	found, err := questionExists(tx, question.ID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return &NotFoundError{"Question", question.ID}
+	}
+
+	_, err = tx.Exec(`
+		update pbe.questions set book = ?, chapter = ?, verses = ?, question = ?
+		where id = ?
+	`, question.Book, question.Chapter, question.Verses, question.Question, question.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, answer := range question.Answers {
+		if len(answer.ID) == 0 {
+			answer.ID, _ = UUID()
+			_, err = tx.Exec(`
+				insert into pbe.answers(id, answer, status, question_id)
+				values(?,?,?,?)
+			`, answer.ID, answer.Answer, answer.Status, question.ID)
+			if err != nil {
+				return fmt.Errorf("Could not create answer: %v", err)
+			}
+			continue
+		}
+
+		found, err = answerExists(tx, question.ID, answer.ID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return &NotFoundError{"Answer", answer.ID}
+		}
+
+		_, err = tx.Exec(`
+			update pbe.answers set answer = ?, status = ?
+			where id = ? and question_id = ?
+		`, answer.Answer, answer.Status, answer.ID, question.ID)
+		if err != nil {
+			return fmt.Errorf("Could not update answer: %s : %v", answer.ID, err)
+		}
+	}
+	return nil
+}
+
+// patchQuestionController updates only the question fields present in the
+// request body.
+func (s *Server) patchQuestionController(c echo.Context) error {
+	questionID := c.Param("questionID")
+	patch := &QuestionPatch{}
+	err := c.Bind(&patch)
+	if err != nil {
+		log.Error("Could not parse question: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse question: "+err.Error())
+	}
+
+	for _, field := range patch.fields() {
+		if field.value == nil {
+			continue
+		}
+		if len(strings.TrimSpace(*field.value)) == 0 {
+			return c.JSON(http.StatusBadRequest, field.name+" is required")
+		}
+		if len(*field.value) > field.max {
+			return c.JSON(http.StatusBadRequest, fmt.Sprintf("%s is longer than %d characters", field.name, field.max))
+		}
+	}
+
+	err = s.store.PatchQuestion(c.Request().Context(), questionID, patch)
+	if _, ok := err.(*NotFoundError); ok {
+		return c.JSON(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		log.Error("Could not update question: ", questionID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not update question: "+questionID+" : "+err.Error())
+	}
+
+	return s.getQuestionController(c)
+}
+
+func patchQuestion(tx *sql.Tx, questionID string, patch *QuestionPatch) error {
+	found, err := questionExists(tx, questionID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return &NotFoundError{"Question", questionID}
+	}
+
+	sets := []string{}
+	args := []interface{}{}
+	for _, field := range patch.fields() {
+		if field.value != nil {
+			sets = append(sets, field.column+" = ?")
+			args = append(args, *field.value)
+		}
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+
+	args = append(args, questionID)
+	_, err = tx.Exec(`
+		update pbe.questions set `+strings.Join(sets, ", ")+`
+		where id = ?
+	`, args...)
+	return err
+}
+
+func (s *Server) updateAnswerController(c echo.Context) error {
+	answer := &Answer{}
+	err := c.Bind(&answer)
+	if err != nil {
+		log.Error("Could not parse answer: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse answer: "+err.Error())
+	}
+
+	return s.saveAnswer(c, &AnswerPatch{
+		Answer: &answer.Answer,
+		Status: &answer.Status,
+	})
+}
+
+func (s *Server) patchAnswerController(c echo.Context) error {
+	patch := &AnswerPatch{}
+	err := c.Bind(&patch)
+	if err != nil {
+		log.Error("Could not parse answer: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse answer: "+err.Error())
+	}
+
+	return s.saveAnswer(c, patch)
+}
+
+// saveAnswer updates the answer in place, keeping its ID so team answers that
+// reference it are preserved.
+func (s *Server) saveAnswer(c echo.Context, patch *AnswerPatch) error {
+	questionID := c.Param("questionID")
+	answerID := c.Param("answerID")
+
+	if patch.Answer != nil {
+		if len(strings.TrimSpace(*patch.Answer)) == 0 {
+			return c.JSON(http.StatusBadRequest, "answer is required")
+		}
+		if len(*patch.Answer) > 500 {
+			return c.JSON(http.StatusBadRequest, "answer is longer than 500 characters")
+		}
+	}
+
+	answer, err := s.store.PatchAnswer(c.Request().Context(), questionID, answerID, patch)
+	if _, ok := err.(*NotFoundError); ok {
+		return c.JSON(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		log.Error("Could not update answer: ", answerID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not update answer: "+answerID+" : "+err.Error())
 	}
 
 	return c.JSON(http.StatusOK, answer)
 }
 
-func addGameController(c echo.Context) error {
+func patchAnswer(tx *sql.Tx, questionID, answerID string, patch *AnswerPatch) (*Answer, error) {
+	found, err := answerExists(tx, questionID, answerID)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, &NotFoundError{"Answer", answerID}
+	}
+
+	sets := []string{}
+	args := []interface{}{}
+	if patch.Answer != nil {
+		sets = append(sets, "answer = ?")
+		args = append(args, *patch.Answer)
+	}
+	if patch.Status != nil {
+		sets = append(sets, "status = ?")
+		args = append(args, *patch.Status)
+	}
+	if len(sets) > 0 {
+		args = append(args, answerID, questionID)
+		_, err = tx.Exec(`
+			update pbe.answers set `+strings.Join(sets, ", ")+`
+			where id = ? and question_id = ?
+		`, args...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	answer := &Answer{ID: answerID}
+	err = tx.QueryRow(`
+		select answer, status from pbe.answers where id = ?
+	`, answerID).Scan(&answer.Answer, &answer.Status)
+	return answer, err
+}
+
+func questionExists(tx *sql.Tx, questionID string) (bool, error) {
+	var count int
+	err := tx.QueryRow(`
+		select count(*) from pbe.questions where id = ?
+	`, questionID).Scan(&count)
+	return count > 0, err
+}
+
+func answerExists(tx *sql.Tx, questionID, answerID string) (bool, error) {
+	var count int
+	err := tx.QueryRow(`
+		select count(*) from pbe.answers where id = ? and question_id = ?
+	`, answerID, questionID).Scan(&count)
+	return count > 0, err
+}
+
+func (s *Server) addGameController(c echo.Context) error {
 	game := &Game{}
 	err := c.Bind(&game)
 	if err != nil {
 		log.Error("Could not create game: ", err)
 		return c.JSON(http.StatusInternalServerError, "Could not create game: "+err.Error())
 	}
+	if game.Answering != nil {
+		err = game.Answering.validate()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+	}
+	if game.Scoring != nil {
+		err = game.Scoring.validate()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+	}
 
+	err = s.store.AddGame(c.Request().Context(), game, currentUser(c))
+	if _, ok := err.(*ClubNotFoundError); ok {
+		return c.JSON(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		log.Error(err)
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	s.publishGames()
+
+	return c.JSON(http.StatusOK, game)
+}
+
+// setDefaults fills in the seed and rules a new game was created without.
+func (game *Game) setDefaults() {
+	if game.Seed == 0 {
+		game.Seed = newSeed()
+	}
+	if game.Scoring == nil {
+		game.Scoring = defaultScoringRules()
+	}
+	if game.Answering == nil {
+		game.Answering = defaultAnswerRules()
+	}
+}
+
+// insertGame creates an open game with its chapters and teams. Each club in
+// game.ClubIDs is enrolled as a team; a game without clubs gets a Home team.
+func insertGame(tx *sql.Tx, game *Game, userID string) error {
 	game.ID, _ = UUID()
+	game.setDefaults()
 
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
+	_, err := tx.Exec(`
+		insert into pbe.games(id, name, seconds, created, questions, status, auto_advance, reject_late, seed, scoring, answering)
+		values(?,?,?,NOW(),?,'OPEN',?,?,?,?,?)
+	`, game.ID, game.Name, game.Seconds, game.Questions, game.AutoAdvance, game.RejectLate, game.Seed, game.Scoring.String(), game.Answering.String())
 	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
+		return fmt.Errorf("Could not create game: %v", err)
 	}
-	defer conn.Close()
 
-	tx, err := conn.Begin()
+	err = addGameEvent(tx, game.ID, "", GameOpen, userID)
 	if err != nil {
-		log.Error("Could not create database transaction: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not create database transaction: "+err.Error())
+		return fmt.Errorf("Could not add game event: %v", err)
 	}
+	game.Status = GameOpen
 
-	_, err = tx.Exec(`
-		insert into pbe.games(id, name, seconds, created, questions, status)
-		values(?,?,?,NOW(),?,'OPEN')
-	`, game.ID, game.Name, game.Seconds, game.Questions)
-	if err != nil {
-		log.Error("Could not create game: ", err)
-		tx.Rollback()
-		return c.JSON(http.StatusInternalServerError, "Could not create game: "+err.Error())
+	game.Teams = []*Team{}
+	for _, clubID := range game.ClubIDs {
+		team, err := enrollClub(tx, game.ID, clubID)
+		if _, ok := err.(*ClubNotFoundError); ok {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("Could not enroll club: %s : %v", clubID, err)
+		}
+		game.Teams = append(game.Teams, team)
 	}
 
-	teamID, _ := UUID()
-	_, err = tx.Exec(`
-		insert into pbe.teams(id, name, game_id)
-		values(?,'Home',?)
-	`, teamID, game.ID)
-	if err != nil {
-		log.Error("Could not add team: ", err)
-		tx.Rollback()
-		return c.JSON(http.StatusInternalServerError, "Could not add team: "+err.Error())
+	// Games played without clubs keep a single Home team.
+	if len(game.Teams) == 0 {
+		teamID, _ := UUID()
+		_, err = tx.Exec(`
+			insert into pbe.teams(id, name, game_id)
+			values(?,'Home',?)
+		`, teamID, game.ID)
+		if err != nil {
+			return fmt.Errorf("Could not add team: %v", err)
+		}
+		game.Teams = append(game.Teams, &Team{ID: teamID, Name: "Home"})
 	}
 
 	for _, chapter := range game.Chapters {
@@ -373,18 +793,14 @@ func addGameController(c echo.Context) error {
 			values(?,?,?,?)
 		`, chapter.ID, chapter.Book, chapter.Chapter, game.ID)
 		if err != nil {
-			log.Error("Could not create game chapter: ", err)
-			tx.Rollback()
-			return c.JSON(http.StatusInternalServerError, "Could not create game chapter: "+err.Error())
+			return fmt.Errorf("Could not create game chapter: %v", err)
 		}
 	}
-
-	tx.Commit()
-	return c.JSON(http.StatusOK, game)
+	return nil
 }
 
-func getGamesController(c echo.Context) error {
-	games, err := getGames()
+func (s *Server) getGamesController(c echo.Context) error {
+	games, err := s.store.Games(c.Request().Context())
 	if err != nil {
 		log.Error("Could not get games: ", err)
 		return c.JSON(http.StatusInternalServerError, "Could not get games: "+err.Error())
@@ -392,17 +808,11 @@ func getGamesController(c echo.Context) error {
 	return c.JSON(http.StatusOK, games)
 }
 
-func getGames() ([]*Game, error) {
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
-	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return nil, err
-	}
-	defer conn.Close()
-
+func getGames(conn *Conn) ([]*Game, error) {
 	rows, err := conn.Query(`
 		select g.id, g.name, g.seconds, g.created, g.questions, coalesce(g.status, 'OPEN'),
-			coalesce(gc.id, ''), coalesce(gc.book, ''), coalesce(gc.chapter, ''), coalesce(t.id, ''), coalesce(t.name, '')
+			g.auto_advance, g.reject_late, g.seed, coalesce(g.scoring, ''), coalesce(g.answering, ''),
+			coalesce(gc.id, ''), coalesce(gc.book, ''), coalesce(gc.chapter, ''), coalesce(t.id, ''), coalesce(t.name, ''), coalesce(t.club_id, '')
 		from pbe.games g
 		left join pbe.game_chapters gc on gc.game_id = g.id
 		left join pbe.teams t on t.game_id = g.id 
@@ -419,19 +829,25 @@ func getGames() ([]*Game, error) {
 	team := &Team{}
 	for rows.Next() {
 		var (
-			id        string
-			name      string
-			seconds   int
-			created   string
-			questions int
-			status    string
-			chapterID string
-			book      string
-			chapter   string
-			teamID    string
-			teamName  string
+			id          string
+			name        string
+			seconds     int
+			created     string
+			questions   int
+			status      string
+			autoAdvance bool
+			rejectLate  bool
+			seed        int64
+			scoring     string
+			answering   string
+			chapterID   string
+			book        string
+			chapter     string
+			teamID      string
+			teamName    string
+			clubID      string
 		)
-		err = rows.Scan(&id, &name, &seconds, &created, &questions, &status, &chapterID, &book, &chapter, &teamID, &teamName)
+		err = rows.Scan(&id, &name, &seconds, &created, &questions, &status, &autoAdvance, &rejectLate, &seed, &scoring, &answering, &chapterID, &book, &chapter, &teamID, &teamName, &clubID)
 		if err != nil {
 			log.Error("Could not get game: ", err)
 			return nil, err
@@ -440,12 +856,17 @@ func getGames() ([]*Game, error) {
 		date, _ := time.Parse("2006-01-02 15:04:05", created)
 		if game.ID != id {
 			game = &Game{
-				ID:        id,
-				Name:      name,
-				Seconds:   seconds,
-				Created:   date,
-				Questions: questions,
-				Status:    status,
+				ID:          id,
+				Name:        name,
+				Seconds:     seconds,
+				Created:     date,
+				Questions:   questions,
+				Status:      status,
+				AutoAdvance: autoAdvance,
+				RejectLate:  rejectLate,
+				Seed:        seed,
+				Scoring:     parseScoringRules(scoring),
+				Answering:   parseAnswerRules(answering),
 			}
 			games = append(games, game)
 		}
@@ -468,8 +889,9 @@ func getGames() ([]*Game, error) {
 		}
 		if len(teamID) > 0 && team.ID != teamID {
 			team = &Team{
-				ID:   teamID,
-				Name: teamName,
+				ID:     teamID,
+				Name:   teamName,
+				ClubID: clubID,
 			}
 			game.Teams = append(game.Teams, team)
 		}
@@ -478,28 +900,32 @@ func getGames() ([]*Game, error) {
 	return games, nil
 }
 
-func getGameController(c echo.Context) error {
+func (s *Server) getGameController(c echo.Context) error {
 	gameID := c.Param("gameID")
 
-	game, err := getGame(gameID)
+	game, err := s.store.Game(c.Request().Context(), gameID)
 	if err != nil {
 		log.Error("Could not get game: ", gameID, " : ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not get game: "+gameID+" : "+err.Error())
+		return c.JSON(gameErrorStatus(err), "Could not get game: "+gameID+" : "+err.Error())
 	}
 	return c.JSON(http.StatusOK, game)
 }
 
-func getGame(gameID string) (*Game, error) {
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
-	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return nil, err
+// requireGame is getGame, but returns sql.ErrNoRows for a game that does not
+// exist.
+func requireGame(conn *Conn, gameID string) (*Game, error) {
+	game, err := getGame(conn, gameID)
+	if err == nil && game.Created.IsZero() {
+		return nil, sql.ErrNoRows
 	}
-	defer conn.Close()
+	return game, err
+}
 
+func getGame(conn *Conn, gameID string) (*Game, error) {
 	rows, err := conn.Query(`
 		select g.name, g.seconds, g.created, g.questions, coalesce(g.status, 'OPEN'),
-			coalesce(gc.id, ''), coalesce(gc.book, ''), coalesce(gc.chapter, ''), coalesce(t.id, ''), coalesce(t.name, '')
+			g.auto_advance, g.reject_late, g.seed, coalesce(g.scoring, ''), coalesce(g.answering, ''),
+			coalesce(gc.id, ''), coalesce(gc.book, ''), coalesce(gc.chapter, ''), coalesce(t.id, ''), coalesce(t.name, ''), coalesce(t.club_id, '')
 		from pbe.games g
 		left join pbe.game_chapters gc on gc.game_id = g.id
 		left join pbe.teams t on t.game_id = g.id 
@@ -517,18 +943,24 @@ func getGame(gameID string) (*Game, error) {
 	team := &Team{}
 	for rows.Next() {
 		var (
-			name      string
-			seconds   int
-			created   string
-			questions int
-			status    string
-			chapterID string
-			book      string
-			chapter   string
-			teamID    string
-			teamName  string
+			name        string
+			seconds     int
+			created     string
+			questions   int
+			status      string
+			autoAdvance bool
+			rejectLate  bool
+			seed        int64
+			scoring     string
+			answering   string
+			chapterID   string
+			book        string
+			chapter     string
+			teamID      string
+			teamName    string
+			clubID      string
 		)
-		err = rows.Scan(&name, &seconds, &created, &questions, &status, &chapterID, &book, &chapter, &teamID, &teamName)
+		err = rows.Scan(&name, &seconds, &created, &questions, &status, &autoAdvance, &rejectLate, &seed, &scoring, &answering, &chapterID, &book, &chapter, &teamID, &teamName, &clubID)
 		if err != nil {
 			log.Error("Could not get game: ", err)
 			return nil, err
@@ -542,6 +974,11 @@ func getGame(gameID string) (*Game, error) {
 			game.Created = date
 			game.Questions = questions
 			game.Status = status
+			game.AutoAdvance = autoAdvance
+			game.RejectLate = rejectLate
+			game.Seed = seed
+			game.Scoring = parseScoringRules(scoring)
+			game.Answering = parseAnswerRules(answering)
 		}
 
 		if len(book) > 0 {
@@ -564,8 +1001,9 @@ func getGame(gameID string) (*Game, error) {
 
 		if len(teamID) > 0 && team.ID != teamID {
 			team = &Team{
-				ID:   teamID,
-				Name: teamName,
+				ID:     teamID,
+				Name:   teamName,
+				ClubID: clubID,
 			}
 			game.Teams = append(game.Teams, team)
 		}
@@ -573,26 +1011,20 @@ func getGame(gameID string) (*Game, error) {
 	return game, nil
 }
 
-func deleteGameController(c echo.Context) error {
+func (s *Server) deleteGameController(c echo.Context) error {
 	gameID := c.Param("gameID")
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
-	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
-	}
-	defer conn.Close()
 
-	_, err = conn.Exec(`
-		delete from pbe.games where id = ?
-	`, gameID)
+	err := s.store.DeleteGame(c.Request().Context(), gameID)
 	if err != nil {
 		log.Error("Could not delete game: ", gameID, " : ", err)
 		return c.JSON(http.StatusInternalServerError, "Could not delete game: "+gameID+" : "+err.Error())
 	}
+
+	s.publishGames()
 	return c.NoContent(http.StatusOK)
 }
 
-func addTeamController(c echo.Context) error {
+func (s *Server) addTeamController(c echo.Context) error {
 	gameID := c.Param("gameID")
 	team := &Team{}
 	err := c.Bind(&team)
@@ -601,357 +1033,207 @@ func addTeamController(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, "Could not create team: "+err.Error())
 	}
 
-	team.ID, _ = UUID()
-
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
-	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
-	}
-	defer conn.Close()
-
-	_, err = conn.Exec(`
-		insert into pbe.teams(id, name, game_id)
-		values(?,?,?)
-	`, team.ID, team.Name, gameID)
+	err = s.store.AddTeam(c.Request().Context(), gameID, team)
 	if err != nil {
 		log.Error("Could not add team: ", err)
 		return c.JSON(http.StatusInternalServerError, "Could not add team: "+err.Error())
 	}
 
+	s.publishGames()
 	return c.JSON(http.StatusOK, team)
 }
 
-func addTeamAnswerController(c echo.Context) error {
+func (s *Server) addTeamAnswerController(c echo.Context) error {
 	gameID := c.Param("gameID")
 	teamID := c.Param("teamID")
 	answerID := c.Param("answerID")
 
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
+	allowed, err := s.answersForTeam(c, teamID)
 	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
+		log.Error("Could not check team membership: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not check team membership: "+err.Error())
+	}
+	if !allowed {
+		return c.JSON(http.StatusForbidden, "You are not a member of this team")
 	}
-	defer conn.Close()
 
-	id, _ := UUID()
-	_, err = conn.Exec(`
-		insert into pbe.team_answers(id, game_id, team_id, answer_id, created)
-		values(?,?,?,?, NOW())
-	`, id, gameID, teamID, answerID)
+	id, err := s.store.AddTeamAnswer(c.Request().Context(), gameID, teamID, answerID)
 	if err != nil {
 		log.Error("Could not add team answer: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not add team answer: "+err.Error())
+		return c.JSON(gameErrorStatus(err), "Could not add team answer: "+err.Error())
 	}
 
+	s.bus.Publish(&Event{
+		Type:    MsgAnswerSubmitted,
+		GameID:  gameID,
+		TeamID:  teamID,
+		Payload: &AnswerSubmittedPayload{TeamID: teamID, AnswerID: answerID},
+	})
+	s.publishScoreboard(gameID)
+
 	return c.JSON(http.StatusOK, id)
 }
 
-func deleteTeamAnswerController(c echo.Context) error {
+func (s *Server) deleteTeamAnswerController(c echo.Context) error {
 	gameID := c.Param("gameID")
 	teamID := c.Param("teamID")
 	answerID := c.Param("answerID")
 
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
+	allowed, err := s.answersForTeam(c, teamID)
 	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
+		log.Error("Could not check team membership: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not check team membership: "+err.Error())
+	}
+	if !allowed {
+		return c.JSON(http.StatusForbidden, "You are not a member of this team")
 	}
-	defer conn.Close()
 
-	_, err = conn.Exec(`
-		delete from pbe.team_answers where game_id = ? and team_id = ? and answer_id = ?
-	`, gameID, teamID, answerID)
+	err = s.store.DeleteTeamAnswer(c.Request().Context(), gameID, teamID, answerID)
 	if err != nil {
 		log.Error("Could not delete team answer: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not delete team answer: "+err.Error())
+		return c.JSON(gameErrorStatus(err), "Could not delete team answer: "+err.Error())
 	}
 
+	s.bus.Publish(&Event{
+		Type:    MsgAnswerSubmitted,
+		GameID:  gameID,
+		TeamID:  teamID,
+		Payload: &AnswerSubmittedPayload{TeamID: teamID, AnswerID: answerID, Removed: true},
+	})
+	s.publishScoreboard(gameID)
+
 	return c.NoContent(http.StatusOK)
 }
 
-func getTeamController(c echo.Context) error {
+func (s *Server) getTeamController(c echo.Context) error {
+	gameID := c.Param("gameID")
 	teamID := c.Param("teamID")
 
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
+	game, err := s.store.Game(c.Request().Context(), gameID)
 	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
-	}
-	defer conn.Close()
-
-	rows, err := conn.Query(`
-		select t.name, a.answer, a.status, coalesce(a.id, ''), coalesce(ta.id, '')
-		from pbe.team_answers ta
-		inner join pbe.teams t on t.id = ta.team_id
-		left join pbe.answers a on a.id = ta.answer_id
-		where t.id = ?
-	`, teamID)
-	if err != nil {
-		log.Error("Could not get team: ", err)
-		return c.JSON(http.StatusOK, "Could not get team: "+err.Error())
+		log.Error("Could not get game: ", gameID, " : ", err)
+		return c.JSON(gameErrorStatus(err), "Could not get game: "+gameID+" : "+err.Error())
 	}
 
-	team := &Team{
-		ID: teamID,
-	}
-	for rows.Next() {
-		var (
-			name         string
-			answer       string
-			status       bool
-			answerID     string
-			teamAnswerID string
-		)
-
-		err = rows.Scan(&name, &answer, &status, &answerID, &teamAnswerID)
-		if err != nil {
-			log.Error("Could not get team: ", err)
-			return c.JSON(http.StatusInternalServerError, "Could not get team: "+err.Error())
-		}
-
-		if len(team.Name) == 0 {
-			team.Name = name
-		}
-
-		if len(answer) > 0 {
-			a := &Answer{
-				ID:           answerID,
-				TeamAnswerID: teamAnswerID,
-				Answer:       answer,
-				Status:       status,
-			}
-			team.Answers = append(team.Answers, a)
+	var team *Team
+	for _, t := range game.Teams {
+		if t.ID == teamID {
+			team = t
 		}
 	}
+	if team == nil {
+		return c.JSON(http.StatusNotFound, "Team not found: "+teamID)
+	}
 
-	return c.JSON(http.StatusOK, team)
+	return s.scoredTeamResponse(c, game, team)
 }
 
-func getHomeTeamController(c echo.Context) error {
-	log.Info("Getting home team!!!")
+func (s *Server) getHomeTeamController(c echo.Context) error {
 	gameID := c.Param("gameID")
 
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
-	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
-	}
-	defer conn.Close()
-
-	rows, err := conn.Query(`
-		select t.id, coalesce(a.answer, ''), coalesce(status, false), coalesce(a.id, ''), coalesce(ta.id, '')
-		from pbe.teams t
-		left join pbe.team_answers ta on t.id = ta.team_id
-		left join pbe.answers a on a.id = ta.answer_id
-		where t.name = 'Home' and t.game_id = ?
-	`, gameID)
+	game, err := s.store.Game(c.Request().Context(), gameID)
 	if err != nil {
-		log.Error("Could not get home team: ", err)
-		return c.JSON(http.StatusOK, "Could not get home team: "+err.Error())
+		log.Error("Could not get game: ", gameID, " : ", err)
+		return c.JSON(gameErrorStatus(err), "Could not get game: "+gameID+" : "+err.Error())
 	}
 
 	team := &Team{
 		Name: "Home",
 	}
-	for rows.Next() {
-		var (
-			id           string
-			answer       string
-			status       bool
-			answerID     string
-			teamAnswerID string
-		)
-
-		err = rows.Scan(&id, &answer, &status, &answerID, &teamAnswerID)
-		if err != nil {
-			log.Error("Could not get home team: ", err)
-			return c.JSON(http.StatusInternalServerError, "Could not get home team: "+err.Error())
+	for _, t := range game.Teams {
+		if t.Name == "Home" {
+			team = t
 		}
+	}
 
-		log.Info("TeamID: ", team.ID, " : ", len(team.ID))
-		if len(team.ID) == 0 {
-			log.Info("Setting teamID: ", id)
-			team.ID = id
-		}
+	return s.scoredTeamResponse(c, game, team)
+}
 
-		if len(answer) > 0 {
-			a := &Answer{
-				ID:           answerID,
-				TeamAnswerID: teamAnswerID,
-				Answer:       answer,
-				Status:       status,
-			}
-			team.Answers = append(team.Answers, a)
-		}
+func (s *Server) scoredTeamResponse(c echo.Context, game *Game, team *Team) error {
+	err := s.store.ScoreTeams(c.Request().Context(), game, []*Team{team})
+	if err != nil {
+		log.Error("Could not get team answers: ", team.ID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get team answers: "+team.ID+" : "+err.Error())
 	}
 
 	return c.JSON(http.StatusOK, team)
 }
 
-func startGameController(c echo.Context) error {
+func (s *Server) startGameController(c echo.Context) error {
 	gameID := c.Param("gameID")
-	game, err := getGame(gameID)
-	if err != nil {
-		log.Error("Could not get game: ", gameID, " : ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not get game: "+gameID+" : "+err.Error())
-	}
+	ctx := c.Request().Context()
 
-	if game.Status != "OPEN" {
-		log.Error("Cannot start a game that is not open")
-		return c.JSON(http.StatusInternalServerError, "Cannot start a game that is not open")
-	}
-
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
+	questions, err := s.store.StartGame(ctx, gameID, currentUser(c))
 	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
+		log.Error("Could not start game: ", gameID, " : ", err)
+		return c.JSON(gameErrorStatus(err), "Could not start game: "+gameID+" : "+err.Error())
 	}
-	defer conn.Close()
 
-	questions := []*Question{}
-	for _, chapter := range game.Chapters {
-		rows, err := conn.Query(`
-			select id
-			from pbe.questions
-			where book = ? and chapter = ?
-			order by verses
-		`, chapter.Book, chapter.Chapter)
-		if err != nil {
-			log.Error("Could not get questions: ", err)
-			return c.JSON(http.StatusInternalServerError, "Could not get questions: "+err.Error())
-		}
-
-		for rows.Next() {
-			var (
-				id string
-			)
-
-			err = rows.Scan(&id)
-			if err != nil {
-				log.Error("Could not get question: ", err)
-				return c.JSON(http.StatusInternalServerError, "Could not get question: "+err.Error())
-			}
-
-			question := &Question{
-				ID: id,
-			}
-			questions = append(questions, question)
-		}
-	}
+	s.publishGame(gameID)
 
-	rand.Shuffle(len(questions), func(i, j int) {
-		questions[i], questions[j] = questions[j], questions[i]
-	})
-
-	tx, err := conn.Begin()
+	game, err := s.store.Game(ctx, gameID)
 	if err != nil {
-		log.Error("Could not start database transaction: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not start database transaction: "+err.Error())
-	}
-
-	_, err = tx.Exec(`
-		update pbe.games set status = ? where id = ?
-	`, "STARTED", gameID)
-	if err != nil {
-		log.Error("Could not start game: ", err)
-		tx.Rollback()
-		return c.JSON(http.StatusInternalServerError, "Could not start game: "+err.Error())
+		log.Error("Could not get game: ", gameID, " : ", err)
+		return c.JSON(gameErrorStatus(err), "Could not get game: "+gameID+" : "+err.Error())
 	}
+	game.Questions2 = questions
+	return c.JSON(http.StatusOK, game)
+}
 
+// insertGameQuestions saves the game's questions in order and makes the first
+// one current.
+func insertGameQuestions(tx *sql.Tx, gameID string, questions []*Question) error {
 	for pos, question := range questions {
 		gameQuestionID, _ := UUID()
-		if pos == 0 {
-			_, err = tx.Exec(`
-				update pbe.games set question = ? where id = ?
-			`, gameQuestionID, gameID)
-			if err != nil {
-				log.Error("Could not set current game question: ", err)
-				tx.Rollback()
-				return c.JSON(http.StatusInternalServerError, "Could not set current game question: "+err.Error())
-			}
-		}
-		_, err = tx.Exec(`
+		_, err := tx.Exec(`
 			insert into pbe.game_questions(id, game_id, question_id, position)
 			values(?,?,?,?)
 		`, gameQuestionID, gameID, question.ID, pos)
 		if err != nil {
-			log.Error("Could not insert game question: ", err)
-			tx.Rollback()
-			return c.JSON(http.StatusInternalServerError, "Could not insert game question: "+err.Error())
+			return fmt.Errorf("Could not insert game question: %v", err)
+		}
+		if pos == 0 {
+			err = setCurrentQuestion(tx, gameID, gameQuestionID)
+			if err != nil {
+				return err
+			}
 		}
 	}
-
-	tx.Commit()
-
-	return c.NoContent(http.StatusOK)
+	return nil
 }
 
-func finishGameController(c echo.Context) error {
+func (s *Server) finishGameController(c echo.Context) error {
 	gameID := c.Param("gameID")
-	game, err := getGame(gameID)
-	if err != nil {
-		log.Error("Could not get game: ", gameID, " : ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not get game: "+gameID+" : "+err.Error())
-	}
 
-	if game.Status != "STARTED" {
-		log.Error("Cannot finish a game that is not started")
-		return c.JSON(http.StatusInternalServerError, "Cannot finish a game that is not started")
-	}
-
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
+	err := s.store.TransitionGame(c.Request().Context(), gameID, GameFinished, currentUser(c))
 	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
+		log.Error("Could not finish game: ", gameID, " : ", err)
+		return c.JSON(gameErrorStatus(err), "Could not finish game: "+gameID+" : "+err.Error())
 	}
-	defer conn.Close()
 
-	tx, err := conn.Begin()
-	if err != nil {
-		log.Error("Could not start database transaction: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not start database transaction: "+err.Error())
-	}
-
-	_, err = tx.Exec(`
-		update pbe.games set status = ? where id = ?
-	`, "FINISHED", gameID)
-	if err != nil {
-		log.Error("Could not finish game: ", err)
-		tx.Rollback()
-		return err
-	}
-
-	tx.Commit()
+	s.publishGame(gameID)
 
 	return c.NoContent(http.StatusOK)
 }
 
-func getCurrentQuestionController(c echo.Context) error {
+func (s *Server) getCurrentQuestionController(c echo.Context) error {
 	gameID := c.Param("gameID")
 
-	question, err := getCurrentQuestion(gameID)
+	question, err := s.store.CurrentQuestion(c.Request().Context(), gameID)
 	if err != nil {
 		log.Error("Could not get current question: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not get current question: "+err.Error())
+		return c.JSON(gameErrorStatus(err), "Could not get current question: "+err.Error())
 	}
 	return c.JSON(http.StatusOK, question)
 }
 
-func getCurrentQuestion(gameID string) (*Question, error) {
+func getCurrentQuestion(conn *Conn, gameID string) (*Question, error) {
 	log.Info("Getting current question")
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
-	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return nil, err
-	}
-	defer conn.Close()
-
 	var (
 		questionID string
 		status     string
 	)
-	err = conn.QueryRow(`
+	err := conn.QueryRow(`
 		select status
 		from pbe.games
 		where id = ?
@@ -961,7 +1243,7 @@ func getCurrentQuestion(gameID string) (*Question, error) {
 		return nil, err
 	}
 
-	if status == "FINISHED" {
+	if status == GameFinished || status == GameCancelled {
 		question := &Question{
 			Finished: true,
 		}
@@ -984,7 +1266,7 @@ func getCurrentQuestion(gameID string) (*Question, error) {
 		return question, nil
 	}
 
-	question, err := getQuestion(questionID)
+	question, err := getQuestion(conn, questionID)
 	if err != nil {
 		log.Error("Could not get current question: ", err)
 		return nil, err
@@ -993,198 +1275,145 @@ func getCurrentQuestion(gameID string) (*Question, error) {
 	return question, nil
 }
 
-func nextQuestionController(c echo.Context) error {
+func (s *Server) nextQuestionController(c echo.Context) error {
 	gameID := c.Param("gameID")
+
+	err := s.store.NextQuestion(c.Request().Context(), gameID, currentUser(c))
+	if err != nil {
+		log.Error("Could not set next question for game: ", gameID, " : ", err)
+		return c.JSON(gameErrorStatus(err), "Could not set next question for game: "+gameID+" : "+err.Error())
+	}
+
+	s.publishGame(gameID)
+
+	return c.NoContent(http.StatusOK)
+}
+
+// nextQuestion moves a started game to the question after the current one,
+// or finishes the game when there are no questions left.
+func nextQuestion(tx *sql.Tx, gameID, userID string) error {
 	var (
 		nextGameQuestionID string
 		position           int
-		questionID         string
 	)
 
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
-	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
-	}
-	defer conn.Close()
-
-	tx, err := conn.Begin()
-	if err != nil {
-		log.Error("Could not start database transaction: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not start database transaction: "+err.Error())
-	}
-	err = tx.QueryRow(`
+	err := tx.QueryRow(`
 		select gq.position
 		from pbe.game_questions gq
 		inner join pbe.games g on g.question = gq.id
 		where gq.game_id = ?
-		and g.status = 'STARTED'
 	`, gameID).Scan(&position)
 	if err != nil {
-		log.Error("Could not get the current question position for game: ", gameID, " : ", err)
-		tx.Rollback()
-		return c.JSON(http.StatusInternalServerError, "Could not get the current question position for game: "+gameID+" : "+err.Error())
+		return fmt.Errorf("Could not get the current question position: %v", err)
 	}
 
-	log.Info("Got position: ", position)
-
 	position++
 
 	log.Info("Looking for position: ", position, " : ", gameID)
 
 	err = tx.QueryRow(`
-		select gq.id, gq.question_id
+		select gq.id
 		from pbe.game_questions gq
-		inner join pbe.games g on g.id = gq.game_id
 		where gq.game_id = ?
 		and gq.position = ?
-	`, gameID, position).Scan(&nextGameQuestionID, &questionID)
+	`, gameID, position).Scan(&nextGameQuestionID)
+	if err == sql.ErrNoRows {
+		log.Info("No more questions, finishing game: ", gameID)
+		_, err = transitionGame(tx, gameID, GameFinished, userID)
+		return err
+	}
 	if err != nil {
-		log.Error("Could not set next question: ", err)
-		_, err = tx.Exec(`
-			update pbe.games set status = ? where id = ?
-		`, "FINISHED", gameID)
-		if err != nil {
-			log.Error("Could not finish game: ", err)
-			tx.Rollback()
-			return err
-		}
+		return fmt.Errorf("Could not get next question: %v", err)
 	}
 
-	_, err = tx.Exec(`
+	return setCurrentQuestion(tx, gameID, nextGameQuestionID)
+}
+
+// setCurrentQuestion makes the game question current and starts its timer.
+func setCurrentQuestion(tx *sql.Tx, gameID, gameQuestionID string) error {
+	_, err := tx.Exec(`
 		update pbe.games set question = ? where id = ?
-	`, nextGameQuestionID, gameID)
+	`, gameQuestionID, gameID)
 	if err != nil {
-		log.Error("Could not set new current question: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not not set new current question: "+err.Error())
+		return fmt.Errorf("Could not set new current question: %v", err)
 	}
 
-	tx.Commit()
+	_, err = tx.Exec(`
+		update pbe.game_questions set started = NOW() where id = ?
+	`, gameQuestionID)
+	if err != nil {
+		return fmt.Errorf("Could not start question timer: %v", err)
+	}
+	return nil
+}
+
+func (s *Server) previousQuestionController(c echo.Context) error {
+	gameID := c.Param("gameID")
+
+	err := s.store.PreviousQuestion(c.Request().Context(), gameID)
+	if err != nil {
+		log.Error("Could not set previous question for game: ", gameID, " : ", err)
+		return c.JSON(gameErrorStatus(err), "Could not set previous question for game: "+gameID+" : "+err.Error())
+	}
+
+	s.publishGame(gameID)
 
 	return c.NoContent(http.StatusOK)
 }
 
-func previousQuestionController(c echo.Context) error {
-	gameID := c.Param("gameID")
+// previousQuestion moves a started game back to the question before the
+// current one. It does nothing on the first question.
+func previousQuestion(tx *sql.Tx, gameID string) error {
 	var (
 		previousGameQuestionID string
 		position               int
-		questionID             string
 	)
 
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
-	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
-	}
-	defer conn.Close()
-
-	tx, err := conn.Begin()
-	if err != nil {
-		log.Error("Could not start database transaction: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not start database transaction: "+err.Error())
-	}
-	err = tx.QueryRow(`
+	err := tx.QueryRow(`
 		select gq.position
 		from pbe.game_questions gq
 		inner join pbe.games g on g.question = gq.id
 		where gq.game_id = ?
-		and g.status = 'STARTED'
 	`, gameID).Scan(&position)
 	if err != nil {
-		log.Error("Could not get the current question position for game: ", gameID, " : ", err)
-		tx.Rollback()
-		return c.JSON(http.StatusInternalServerError, "Could not get the current question position for game: "+gameID+" : "+err.Error())
+		return fmt.Errorf("Could not get the current question position: %v", err)
 	}
 
 	if position == 0 {
 		// Already at position 0
-		tx.Commit()
-		return c.NoContent(http.StatusOK)
+		return nil
 	}
 
 	position--
 
 	err = tx.QueryRow(`
-		select gq.id, gq.question_id
+		select gq.id
 		from pbe.game_questions gq
-		inner join pbe.games g on g.id = gq.game_id
 		where gq.game_id = ?
 		and gq.position = ?
-	`, gameID, position).Scan(&previousGameQuestionID, &questionID)
+	`, gameID, position).Scan(&previousGameQuestionID)
 	if err != nil {
-		log.Error("Could not get previous question: ", gameID, " : ", position, " : ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not get previous question: "+err.Error())
+		return fmt.Errorf("Could not get previous question: %v", err)
 	}
 
-	_, err = tx.Exec(`
-		update pbe.games set question = ? where id = ?
-	`, previousGameQuestionID, gameID)
-	if err != nil {
-		log.Error("Could not set new current question: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not not set new current question: "+err.Error())
-	}
-
-	tx.Commit()
-
-	return c.NoContent(http.StatusOK)
+	return setCurrentQuestion(tx, gameID, previousGameQuestionID)
 }
 
-func getFinishedGameController(c echo.Context) error {
+func (s *Server) getFinishedGameController(c echo.Context) error {
 	gameID := c.Param("gameID")
-	game, err := getGame(gameID)
 
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
-	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not connect to database: "+err.Error())
-	}
-	defer conn.Close()
-
-	for _, team := range game.Teams {
-		log.Info("Getting results for: ", team.Name, " : ", team.ID)
-		rows, err := conn.Query(`
-			select a.answer, a.status, a.id, ta.id
-			from pbe.team_answers ta
-			inner join pbe.teams t on t.id = ta.team_id
-			inner join pbe.answers a on a.id = ta.answer_id
-			where t.id = ?
-		`, team.ID)
-		if err != nil {
-			log.Error("Could not get team: ", err)
-			return c.JSON(http.StatusInternalServerError, "Could not get team: "+err.Error())
-		}
-
-		for rows.Next() {
-			var (
-				answer       string
-				status       bool
-				answerID     string
-				teamAnswerID string
-			)
+	ctx := c.Request().Context()
 
-			err = rows.Scan(&answer, &status, &answerID, &teamAnswerID)
-			if err != nil {
-				log.Error("Could not get team answer: ", err)
-				return c.JSON(http.StatusInternalServerError, "Could not get team answer: "+err.Error())
-			}
+	game, err := s.store.Game(ctx, gameID)
+	if err != nil {
+		log.Error("Could not get game: ", gameID, " : ", err)
+		return c.JSON(gameErrorStatus(err), "Could not get game: "+gameID+" : "+err.Error())
+	}
 
-			log.Info("Status: ", status)
-			if len(answer) > 0 {
-				a := &Answer{
-					ID:           answerID,
-					TeamAnswerID: teamAnswerID,
-					Answer:       answer,
-					Status:       status,
-				}
-				if a.Status {
-					team.Points++
-				} else if team.Points > 0 {
-					team.Points--
-				}
-				team.Answers = append(team.Answers, a)
-			}
-		}
+	err = s.store.ScoreTeams(ctx, game, game.Teams)
+	if err != nil {
+		log.Error("Could not score game: ", gameID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not score game: "+gameID+" : "+err.Error())
 	}
 
 	return c.JSON(http.StatusOK, game)
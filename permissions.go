@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// Roles a user can have in the JWT scope claim
+const (
+	RoleAdmin      = "ADMIN"
+	RoleCounselor  = "COUNSELOR"
+	RolePathfinder = "PATHFINDER"
+	RoleParent     = "PARENT"
+)
+
+// userScopes returns the scope claim of the request's JWT.
+func userScopes(c echo.Context) []string {
+	scopes := []string{}
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return scopes
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return scopes
+	}
+	values, _ := claims["scope"].([]interface{})
+	for _, value := range values {
+		if scope, ok := value.(string); ok {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// hasRole reports whether the user has any of the roles. Admins have every
+// role.
+func hasRole(c echo.Context, roles ...string) bool {
+	for _, scope := range userScopes(c) {
+		if scope == RoleAdmin {
+			return true
+		}
+		for _, role := range roles {
+			if scope == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requireRoles only lets requests through when the user has one of the roles.
+// It must run after the JWT middleware.
+func requireRoles(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !hasRole(c, roles...) {
+				log.Warn("User ", currentUser(c), " is not allowed to ", c.Request().Method, " ", c.Path())
+				return c.JSON(http.StatusForbidden, "You are not allowed to do this")
+			}
+			return next(c)
+		}
+	}
+}
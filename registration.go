@@ -74,7 +74,7 @@ func login(c echo.Context) error {
 	return c.Redirect(http.StatusTemporaryRedirect, url)
 }
 
-func auth(c echo.Context) error {
+func (s *Server) auth(c echo.Context) error {
 	filter := TokenFilter{}
 	err := json.NewDecoder(c.Request().Body).Decode(&filter)
 	if err != nil {
@@ -134,69 +134,26 @@ func auth(c echo.Context) error {
 	scopes := []string{}
 	scopes = append(scopes, "openid")
 
-	var (
-		id        string
-		firstName string
-		lastName  string
-		gender    string
-		image     string
-	)
-
-	conn, err := sql.Open("mysql", viper.GetString("database.url"))
-	if err != nil {
-		log.Error("Open connection failed: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not open database : "+err.Error())
-	}
-	defer conn.Close()
-
-	err = conn.QueryRow(`
-		select
-			id
-			, first_name
-			, last_name
-			, coalesce(gender, 'male')
-			, coalesce(image_url, '')
-		from users
-		where email = ?
-	`, email).Scan(&id, &firstName, &lastName, &gender, &image)
-	if err != nil {
-		log.Info("User: ", email, " not found... Creating...", err)
-		id, _ = UUID()
-		firstName = googleUser.Name.GivenName
-		lastName = googleUser.Name.FamilyName
-		gender = googleUser.Gender
-		image = googleUser.Image.URL
-
-		_, err = conn.Exec(`
-			insert into users(id, first_name, last_name, email, gender, image_url)
-			values(?,?,?,?,?,?)
-		`, id, firstName, lastName, email, gender, image)
+	user, err := s.store.User(c.Request().Context(), email)
+	if err == sql.ErrNoRows {
+		log.Info("User: ", email, " not found... Creating...")
+		user = &User{
+			FirstName: googleUser.Name.GivenName,
+			LastName:  googleUser.Name.FamilyName,
+			Email:     email,
+			Gender:    googleUser.Gender,
+			Image:     googleUser.Image.URL,
+		}
+		err = s.store.AddUser(c.Request().Context(), user)
 		if err != nil {
 			log.Error("Could not create user: ", email, " : ", err)
 			return c.JSON(http.StatusInternalServerError, "Could not create user: "+email+" : "+err.Error())
 		}
+	} else if err != nil {
+		log.Error("Could not get user: ", email, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get user: "+email+" : "+err.Error())
 	}
-
-	rows, err := conn.Query(`
-		select 
-			role_id
-		from user_roles
-		where user_id = ?
-	`, id)
-	if err != nil {
-		log.Error("Could not get user roles: ", err)
-		return c.JSON(http.StatusInternalServerError, "Could not get user roles: "+err.Error())
-	}
-
-	for rows.Next() {
-		var scope string
-		err = rows.Scan(&scope)
-		if err != nil {
-			log.Error(http.StatusInternalServerError, "Could not read user scope: ", err)
-			return c.JSON(http.StatusInternalServerError, "Could not read user scope: "+err.Error())
-		}
-		scopes = append(scopes, scope)
-	}
+	scopes = append(scopes, user.Roles...)
 
 	token := jwt.New(jwt.SigningMethodRS256)
 
@@ -214,14 +171,14 @@ func auth(c echo.Context) error {
 	claims["azp"] = clientID
 	claims["grant_type"] = grantType
 	claims["user_id"] = email
-	claims["image_url"] = image
-	claims["name"] = firstName + " " + lastName
-	claims["first_name"] = firstName
-	claims["last_name"] = lastName
+	claims["image_url"] = user.Image
+	claims["name"] = user.FirstName + " " + user.LastName
+	claims["first_name"] = user.FirstName
+	claims["last_name"] = user.LastName
 	claims["username"] = email
 	claims["user_name"] = email
 	claims["email"] = email
-	claims["gender"] = gender
+	claims["gender"] = user.Gender
 	claims["auth_time"] = issued
 	claims["iat"] = issued
 	claims["exp"] = expires
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// Response statuses
+const (
+	ResponsePending = "PENDING"
+	ResponseCorrect = "CORRECT"
+	ResponsePartial = "PARTIAL"
+	ResponseWrong   = "WRONG"
+)
+
+// How a response was graded
+const (
+	MatchExact = "EXACT"
+	MatchFuzzy = "FUZZY"
+	MatchJudge = "JUDGE"
+)
+
+// TeamResponse struct
+type TeamResponse struct {
+	ID             string     `json:"id"`
+	GameID         string     `json:"gameId"`
+	GameQuestionID string     `json:"gameQuestionId"`
+	QuestionID     string     `json:"questionId"`
+	Question       string     `json:"question"`
+	TeamID         string     `json:"teamId"`
+	TeamName       string     `json:"teamName"`
+	Response       string     `json:"response"`
+	Normalized     string     `json:"normalized"`
+	AnswerID       string     `json:"answerId"`
+	Status         string     `json:"status"`
+	MatchedBy      string     `json:"matchedBy"`
+	JudgeID        string     `json:"judgeId"`
+	Late           bool       `json:"late"`
+	Created        time.Time  `json:"created"`
+	Graded         *time.Time `json:"graded"`
+}
+
+// maxResponseLength is the longest response a team can type, the size of the
+// response and answer columns.
+const maxResponseLength = 500
+
+// ResponseGrade struct
+type ResponseGrade struct {
+	Status string `json:"status"`
+	Accept bool   `json:"accept"`
+}
+
+const teamResponseColumns = `
+	select tr.id, tr.game_id, tr.game_question_id, gq.question_id, q.question, tr.team_id, t.name,
+		tr.response, tr.normalized, coalesce(tr.answer_id, ''), tr.status, coalesce(tr.matched_by, ''),
+		coalesce(tr.judge_id, ''), tr.late = 1, tr.created, coalesce(tr.graded, '')
+	from pbe.team_responses tr
+	inner join pbe.game_questions gq on gq.id = tr.game_question_id
+	inner join pbe.questions q on q.id = gq.question_id
+	inner join pbe.teams t on t.id = tr.team_id
+`
+
+// normalizeResponse lowercases a typed response, turns punctuation into
+// spaces, collapses whitespace and drops a leading article, so "The Jordan!"
+// and "jordan" compare equal.
+func normalizeResponse(response string) string {
+	words := strings.Fields(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, response))
+	if len(words) > 1 && (words[0] == "the" || words[0] == "a" || words[0] == "an") {
+		words = words[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// levenshtein is the number of single letter edits between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// fuzzyTolerance is how many typos a response may have and still match an
+// accepted answer: none for short answers, then one per five letters.
+func fuzzyTolerance(answer string) int {
+	n := len([]rune(answer))
+	if n < 4 {
+		return 0
+	}
+	if n < 10 {
+		return 1
+	}
+	return n / 5
+}
+
+// matchResponse grades a normalized response against the question's answers.
+// Answers marked correct are accepted answers, the others known wrong ones.
+// With fuzzy matching a response with a few typos takes the grade of the
+// closest answer, unless a correct and a wrong answer are equally close.
+// Responses that match nothing are left for a judge.
+func matchResponse(normalized string, answers []*Answer, fuzzy bool) (answerID, status, matchedBy string) {
+	for _, answer := range answers {
+		if normalizeResponse(answer.Answer) != normalized {
+			continue
+		}
+		if answer.Status {
+			return answer.ID, ResponseCorrect, MatchExact
+		}
+		return answer.ID, ResponseWrong, MatchExact
+	}
+
+	if fuzzy {
+		best := -1
+		var closest *Answer
+		tied := false
+		for _, answer := range answers {
+			known := normalizeResponse(answer.Answer)
+			distance := levenshtein(normalized, known)
+			if distance > fuzzyTolerance(known) {
+				continue
+			}
+			if best < 0 || distance < best {
+				best = distance
+				closest = answer
+				tied = false
+			} else if distance == best && answer.Status != closest.Status {
+				tied = true
+			}
+		}
+		if closest != nil && !tied {
+			if closest.Status {
+				return closest.ID, ResponseCorrect, MatchFuzzy
+			}
+			return closest.ID, ResponseWrong, MatchFuzzy
+		}
+	}
+
+	return "", ResponsePending, ""
+}
+
+// submitTeamResponse records a typed response to the game's current question
+// and grades it when it matches a known answer. The caller checks the user may
+// answer for the team.
+func submitTeamResponse(tx *sql.Tx, gameID, teamID, text string) (*TeamResponse, error) {
+	_, err := requireGameStatus(tx, gameID, GameStarted)
+	if err == nil {
+		err = requireGameTeam(tx, gameID, teamID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := gameAnswerRules(tx, gameID)
+	if err != nil {
+		return nil, err
+	}
+	if rules.Mode != AnswerText {
+		return nil, &AnswerRuleError{"This game takes selected answers"}
+	}
+
+	timer, err := getGameTimer(tx, gameID)
+	if err != nil {
+		return nil, err
+	}
+	if len(timer.GameQuestionID) == 0 {
+		return nil, &AnswerRuleError{"The game has no current question"}
+	}
+	if timer.Expired && timer.RejectLate {
+		return nil, &AnswerRuleError{"Time is up for the current question"}
+	}
+
+	response := &TeamResponse{
+		GameID:         gameID,
+		GameQuestionID: timer.GameQuestionID,
+		TeamID:         teamID,
+		Response:       strings.TrimSpace(text),
+		Late:           timer.Expired,
+		Created:        time.Now(),
+	}
+	response.Normalized = normalizeResponse(response.Response)
+
+	var selected, duplicates, correct int
+	err = tx.QueryRow(`
+		select count(*), coalesce(sum(normalized = ?), 0), coalesce(sum(status = 'CORRECT'), 0)
+		from pbe.team_responses
+		where game_question_id = ? and team_id = ?
+	`, response.Normalized, timer.GameQuestionID, teamID).Scan(&selected, &duplicates, &correct)
+	if err != nil {
+		return nil, err
+	}
+	if duplicates > 0 {
+		return nil, &AnswerRuleError{"This response has already been submitted"}
+	}
+	// A typed response answers the whole question, so once one is correct
+	// another spelling of it can not score again.
+	if correct > 0 {
+		return nil, &AnswerRuleError{"This question has already been answered correctly"}
+	}
+	if rules.LockIn {
+		locked, err := isTeamLocked(tx, timer.GameQuestionID, teamID)
+		if err != nil {
+			return nil, err
+		}
+		if locked {
+			return nil, &AnswerRuleError{"Answers to this question are locked in"}
+		}
+	}
+	if limit := rules.limit(); limit > 0 && selected >= limit {
+		if limit == 1 {
+			return nil, &AnswerRuleError{"Only one response can be given for this question"}
+		}
+		return nil, &AnswerRuleError{fmt.Sprintf("Only %d responses can be given for this question", limit)}
+	}
+
+	err = tx.QueryRow(`
+		select question_id from pbe.game_questions where id = ?
+	`, timer.GameQuestionID).Scan(&response.QuestionID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		select id, answer, status from pbe.answers where question_id = ?
+	`, response.QuestionID)
+	if err != nil {
+		return nil, err
+	}
+	answers := []*Answer{}
+	for rows.Next() {
+		answer := &Answer{}
+		err = rows.Scan(&answer.ID, &answer.Answer, &answer.Status)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		answers = append(answers, answer)
+	}
+	rows.Close()
+
+	response.AnswerID, response.Status, response.MatchedBy = matchResponse(response.Normalized, answers, rules.Fuzzy)
+	if response.Status != ResponsePending {
+		response.Graded = &response.Created
+	}
+
+	response.ID, _ = UUID()
+	_, err = tx.Exec(`
+		insert into pbe.team_responses(id, game_id, game_question_id, team_id, response, normalized,
+			answer_id, status, matched_by, late, created, graded)
+		values(?,?,?,?,?,?,nullif(?, ''),?,nullif(?, ''),?,NOW(),if(? = 'PENDING', null, NOW()))
+	`, response.ID, gameID, timer.GameQuestionID, teamID, response.Response, response.Normalized,
+		response.AnswerID, response.Status, response.MatchedBy, response.Late, response.Status)
+	if isDuplicateKey(err) {
+		return nil, &AnswerRuleError{"This response has already been submitted"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (s *Server) addTeamResponseController(c echo.Context) error {
+	gameID := c.Param("gameID")
+	teamID := c.Param("teamID")
+	response := &TeamResponse{}
+	err := c.Bind(&response)
+	if err != nil {
+		log.Error("Could not parse response: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse response: "+err.Error())
+	}
+	if len(normalizeResponse(response.Response)) == 0 {
+		return c.JSON(http.StatusBadRequest, "response is required")
+	}
+	if len([]rune(strings.TrimSpace(response.Response))) > maxResponseLength {
+		return c.JSON(http.StatusBadRequest, fmt.Sprintf("response can not be longer than %d characters", maxResponseLength))
+	}
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	tx, err := conn.Begin()
+	if err != nil {
+		log.Error("Could not start database transaction: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not start database transaction: "+err.Error())
+	}
+
+	allowed, err := canAnswerForTeam(c, tx, teamID)
+	if err != nil {
+		log.Error("Could not check team membership: ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not check team membership: "+err.Error())
+	}
+	if !allowed {
+		tx.Rollback()
+		return c.JSON(http.StatusForbidden, "You are not a member of this team")
+	}
+
+	response, err = submitTeamResponse(tx, gameID, teamID, response.Response)
+	if err != nil {
+		log.Error("Could not add team response: ", err)
+		tx.Rollback()
+		return c.JSON(gameErrorStatus(err), "Could not add team response: "+err.Error())
+	}
+
+	tx.Commit()
+
+	s.publishScoreboard(gameID)
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// deleteTeamResponseController takes back a response to the current question
+// that no judge has graded yet.
+func (s *Server) deleteTeamResponseController(c echo.Context) error {
+	gameID := c.Param("gameID")
+	teamID := c.Param("teamID")
+	responseID := c.Param("responseID")
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	tx, err := conn.Begin()
+	if err != nil {
+		log.Error("Could not start database transaction: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not start database transaction: "+err.Error())
+	}
+
+	_, err = requireGameStatus(tx, gameID, GameStarted)
+	if err == nil {
+		err = requireGameTeam(tx, gameID, teamID)
+	}
+	if err != nil {
+		log.Error("Could not delete team response: ", err)
+		tx.Rollback()
+		return c.JSON(gameErrorStatus(err), "Could not delete team response: "+err.Error())
+	}
+
+	allowed, err := canAnswerForTeam(c, tx, teamID)
+	if err != nil {
+		log.Error("Could not check team membership: ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not check team membership: "+err.Error())
+	}
+	if !allowed {
+		tx.Rollback()
+		return c.JSON(http.StatusForbidden, "You are not a member of this team")
+	}
+
+	timer, err := getGameTimer(tx, gameID)
+	if err != nil {
+		log.Error("Could not get question timer: ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not get question timer: "+err.Error())
+	}
+
+	err = checkTeamAnswerRemoval(tx, gameID, timer.GameQuestionID, teamID)
+	if err != nil {
+		log.Error("Could not delete team response: ", err)
+		tx.Rollback()
+		return c.JSON(gameErrorStatus(err), "Could not delete team response: "+err.Error())
+	}
+
+	_, err = tx.Exec(`
+		delete from pbe.team_responses
+		where id = ? and team_id = ? and game_question_id = ? and coalesce(matched_by, '') <> 'JUDGE'
+	`, responseID, teamID, timer.GameQuestionID)
+	if err != nil {
+		log.Error("Could not delete team response: ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not delete team response: "+err.Error())
+	}
+
+	tx.Commit()
+
+	s.publishScoreboard(gameID)
+
+	return c.NoContent(http.StatusOK)
+}
+
+// getResponsesController is the judges' review queue. It lists the game's
+// pending responses oldest first, or every response with status=ALL.
+func (s *Server) getResponsesController(c echo.Context) error {
+	gameID := c.Param("gameID")
+	status := strings.ToUpper(c.QueryParam("status"))
+	if len(status) == 0 {
+		status = ResponsePending
+	}
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	rows, err := conn.Query(teamResponseColumns+`
+		where tr.game_id = ? and (? = 'ALL' or tr.status = ?)
+		order by tr.created, tr.id
+	`, gameID, status, status)
+	if err != nil {
+		log.Error("Could not get responses: ", gameID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get responses: "+gameID+" : "+err.Error())
+	}
+	defer rows.Close()
+
+	responses := []*TeamResponse{}
+	for rows.Next() {
+		response, err := scanTeamResponse(rows)
+		if err != nil {
+			log.Error("Could not get response: ", err)
+			return c.JSON(http.StatusInternalServerError, "Could not get response: "+err.Error())
+		}
+		responses = append(responses, response)
+	}
+
+	return c.JSON(http.StatusOK, responses)
+}
+
+// gradeResponseController lets a judge mark a response correct, partially
+// correct or wrong. Pending responses to the same question with the same
+// wording get the same grade. With accept a correct response is added to the
+// question's accepted answers so it is matched automatically from then on,
+// unless the question already has an answer with the same wording.
+func (s *Server) gradeResponseController(c echo.Context) error {
+	gameID := c.Param("gameID")
+	responseID := c.Param("responseID")
+	grade := &ResponseGrade{}
+	err := c.Bind(grade)
+	if err != nil {
+		log.Error("Could not parse grade: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse grade: "+err.Error())
+	}
+	grade.Status = strings.ToUpper(grade.Status)
+	if grade.Status != ResponseCorrect && grade.Status != ResponsePartial && grade.Status != ResponseWrong {
+		return c.JSON(http.StatusBadRequest, "status must be "+ResponseCorrect+", "+ResponsePartial+" or "+ResponseWrong)
+	}
+	if grade.Accept && grade.Status != ResponseCorrect {
+		return c.JSON(http.StatusBadRequest, "Only correct responses can be accepted as answers")
+	}
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	tx, err := conn.Begin()
+	if err != nil {
+		log.Error("Could not start database transaction: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not start database transaction: "+err.Error())
+	}
+
+	response, err := scanTeamResponse(tx.QueryRow(teamResponseColumns+`
+		where tr.id = ? and tr.game_id = ?
+		for update
+	`, responseID, gameID))
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return c.JSON(http.StatusNotFound, "Response not found: "+responseID)
+	}
+	if err != nil {
+		log.Error("Could not get response: ", responseID, " : ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not get response: "+responseID+" : "+err.Error())
+	}
+
+	if grade.Accept {
+		response.AnswerID, err = acceptResponse(tx, response)
+		if _, ok := err.(*AnswerRuleError); ok {
+			tx.Rollback()
+			return c.JSON(http.StatusConflict, err.Error())
+		}
+		if err != nil {
+			log.Error("Could not add accepted answer: ", err)
+			tx.Rollback()
+			return c.JSON(http.StatusInternalServerError, "Could not add accepted answer: "+err.Error())
+		}
+	}
+
+	_, err = tx.Exec(`
+		update pbe.team_responses
+		set status = ?, matched_by = 'JUDGE', judge_id = ?, graded = NOW(), answer_id = nullif(?, '')
+		where id = ? or (game_question_id = ? and normalized = ? and status = 'PENDING')
+	`, grade.Status, currentUser(c), response.AnswerID, responseID, response.GameQuestionID, response.Normalized)
+	if err != nil {
+		log.Error("Could not grade response: ", responseID, " : ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not grade response: "+responseID+" : "+err.Error())
+	}
+
+	tx.Commit()
+
+	s.publishScoreboard(gameID)
+
+	response, err = scanTeamResponse(conn.QueryRow(teamResponseColumns+`
+		where tr.id = ?
+	`, responseID))
+	if err != nil {
+		log.Error("Could not get response: ", responseID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get response: "+responseID+" : "+err.Error())
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// acceptResponse adds the response to its question's accepted answers and
+// returns the answer's id. A correct answer with the same wording is reused
+// rather than added again, and a wrong one is a conflict for the judge to
+// sort out in the question bank.
+func acceptResponse(tx *sql.Tx, response *TeamResponse) (string, error) {
+	rows, err := tx.Query(`
+		select id, answer, status from pbe.answers where question_id = ? for update
+	`, response.QuestionID)
+	if err != nil {
+		return "", err
+	}
+	answers := []*Answer{}
+	for rows.Next() {
+		answer := &Answer{}
+		err = rows.Scan(&answer.ID, &answer.Answer, &answer.Status)
+		if err != nil {
+			rows.Close()
+			return "", err
+		}
+		answers = append(answers, answer)
+	}
+	rows.Close()
+
+	for _, answer := range answers {
+		if normalizeResponse(answer.Answer) != response.Normalized {
+			continue
+		}
+		if !answer.Status {
+			return "", &AnswerRuleError{"The question has this answer marked wrong: " + answer.ID}
+		}
+		return answer.ID, nil
+	}
+
+	answerID, _ := UUID()
+	_, err = tx.Exec(`
+		insert into pbe.answers(id, answer, status, question_id)
+		values(?,?,1,?)
+	`, answerID, response.Response, response.QuestionID)
+	return answerID, err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTeamResponse(row rowScanner) (*TeamResponse, error) {
+	var created, graded string
+	response := &TeamResponse{}
+	err := row.Scan(&response.ID, &response.GameID, &response.GameQuestionID, &response.QuestionID,
+		&response.Question, &response.TeamID, &response.TeamName, &response.Response, &response.Normalized, &response.AnswerID,
+		&response.Status, &response.MatchedBy, &response.JudgeID, &response.Late, &created, &graded)
+	if err != nil {
+		return nil, err
+	}
+	response.Created, _ = time.Parse("2006-01-02 15:04:05", created)
+	if date, err := time.Parse("2006-01-02 15:04:05", graded); err == nil {
+		response.Graded = &date
+	}
+	return response, nil
+}
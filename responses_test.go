@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestMatchResponse(t *testing.T) {
+	answers := []*Answer{
+		{ID: "jordan", Answer: "The Jordan", Status: true},
+		{ID: "jericho", Answer: "Jericho", Status: true},
+		{ID: "jerash", Answer: "Jerash", Status: false},
+		{ID: "nile", Answer: "Nile", Status: false},
+		{ID: "sinai", Answer: "Sinai", Status: true},
+		{ID: "sinah", Answer: "Sinah", Status: false},
+	}
+
+	tests := []struct {
+		response  string
+		fuzzy     bool
+		answerID  string
+		status    string
+		matchedBy string
+	}{
+		{"jordan!", false, "jordan", ResponseCorrect, MatchExact},
+		{"the nile", false, "nile", ResponseWrong, MatchExact},
+		{"jordon", false, "", ResponsePending, ""},
+		{"jordon", true, "jordan", ResponseCorrect, MatchFuzzy},
+		// Closer to a known wrong answer than to a correct one.
+		{"jerach", true, "jerash", ResponseWrong, MatchFuzzy},
+		{"jerich", true, "jericho", ResponseCorrect, MatchFuzzy},
+		// As close to a correct answer as to a wrong one.
+		{"sinau", true, "", ResponsePending, ""},
+		{"euphrates", true, "", ResponsePending, ""},
+	}
+
+	for _, test := range tests {
+		answerID, status, matchedBy := matchResponse(normalizeResponse(test.response), answers, test.fuzzy)
+		if answerID != test.answerID || status != test.status || matchedBy != test.matchedBy {
+			t.Errorf("%q (fuzzy %v) matched %q %s %s, want %q %s %s", test.response, test.fuzzy,
+				answerID, status, matchedBy, test.answerID, test.status, test.matchedBy)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// Scoreboard struct
+type Scoreboard struct {
+	Type   string            `json:"type"`
+	GameID string            `json:"gameId"`
+	Status string            `json:"status"`
+	Teams  []*ScoreboardTeam `json:"teams"`
+}
+
+// ScoreboardTeam struct
+type ScoreboardTeam struct {
+	TeamID string           `json:"teamId"`
+	ClubID string           `json:"clubId"`
+	Name   string           `json:"name"`
+	Points float64          `json:"points"`
+	Rank   int              `json:"rank"`
+	Scores []*QuestionScore `json:"scores"`
+}
+
+// getScoreboardController returns the full scoreboard to moderators and the
+// players' scoreboard to everyone else.
+func (s *Server) getScoreboardController(c echo.Context) error {
+	gameID := c.Param("gameID")
+
+	players := !hasRole(c, RoleCounselor)
+	scoreboard, err := s.store.Scoreboard(c.Request().Context(), gameID, players)
+	if err != nil {
+		log.Error("Could not get scoreboard: ", gameID, " : ", err)
+		return c.JSON(gameErrorStatus(err), "Could not get scoreboard: "+gameID+" : "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, scoreboard)
+}
+
+// getScoreboard scores every team in the game so far and ranks them.
+func getScoreboard(conn *Conn, gameID string) (*Scoreboard, error) {
+	return buildScoreboard(conn, gameID, false)
+}
+
+// buildScoreboard is the full scoreboard, or with players set the scoreboard
+// players see.
+func buildScoreboard(conn *Conn, gameID string, players bool) (*Scoreboard, error) {
+	game, err := requireGame(conn, gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	answers, err := getScoredAnswers(conn, gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	var asking string
+	if players && (game.Status == GameStarted || game.Status == GamePaused) {
+		err = conn.QueryRow(`
+			select gq.question_id
+			from pbe.games g
+			inner join pbe.game_questions gq on gq.id = g.question
+			where g.id = ?
+		`, gameID).Scan(&asking)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	return newScoreboard(game, answers, asking), nil
+}
+
+// newScoreboard scores the game's teams from their answers and ranks them.
+// Teams with the same points share a rank.
+//
+// Answers to the hidden question are left out. Players get the question being
+// asked hidden, so neither the points nor the per-question scores give away
+// which teams have answered it correctly until the game moves on.
+func newScoreboard(game *Game, answers map[string][]*scoredAnswer, hidden string) *Scoreboard {
+	if len(hidden) > 0 {
+		for teamID, teamAnswers := range answers {
+			shown := []*scoredAnswer{}
+			for _, answer := range teamAnswers {
+				if answer.questionID != hidden {
+					shown = append(shown, answer)
+				}
+			}
+			answers[teamID] = shown
+		}
+	}
+	scoreTeamAnswers(game, game.Teams, answers)
+
+	scoreboard := &Scoreboard{
+		Type:   "scoreboard",
+		GameID: game.ID,
+		Status: game.Status,
+		Teams:  []*ScoreboardTeam{},
+	}
+	for _, team := range game.Teams {
+		scoreboard.Teams = append(scoreboard.Teams, &ScoreboardTeam{
+			TeamID: team.ID,
+			ClubID: team.ClubID,
+			Name:   team.Name,
+			Points: team.Points,
+			Scores: team.Scores,
+		})
+	}
+
+	sort.SliceStable(scoreboard.Teams, func(i, j int) bool {
+		return scoreboard.Teams[i].Points > scoreboard.Teams[j].Points
+	})
+	for i, team := range scoreboard.Teams {
+		team.Rank = i + 1
+		if i > 0 && team.Points == scoreboard.Teams[i-1].Points {
+			team.Rank = scoreboard.Teams[i-1].Rank
+		}
+	}
+
+	return scoreboard
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// ScoringRules struct
+type ScoringRules struct {
+	Correct       float64 `json:"correct"`
+	Wrong         float64 `json:"wrong"`
+	FloorAtZero   bool    `json:"floorAtZero"`
+	PartialCredit bool    `json:"partialCredit"`
+	SpeedBonus    float64 `json:"speedBonus"`
+	IgnoreLate    bool    `json:"ignoreLate"`
+	PartialAnswer float64 `json:"partialAnswer"`
+}
+
+// QuestionScore struct
+type QuestionScore struct {
+	QuestionID string  `json:"questionId"`
+	Correct    int     `json:"correct"`
+	Wrong      int     `json:"wrong"`
+	Partial    int     `json:"partial"`
+	Points     float64 `json:"points"`
+	Bonus      float64 `json:"bonus"`
+}
+
+// scoredAnswer is a team answer with what the scoring engine needs to know
+// about its question.
+type scoredAnswer struct {
+	answer       *Answer
+	teamID       string
+	questionID   string
+	correctCount int
+	elapsed      int
+	partial      bool
+	response     bool
+}
+
+// defaultScoringRules gives one point per correct answer and takes one away
+// per wrong answer without going below zero. Typed responses a judge marks
+// partially correct are worth half a correct answer.
+func defaultScoringRules() *ScoringRules {
+	return &ScoringRules{
+		Correct:       1,
+		Wrong:         1,
+		FloorAtZero:   true,
+		PartialAnswer: 0.5,
+	}
+}
+
+// parseScoringRules reads the rules stored with a game, falling back to the
+// defaults for games created before rules existed.
+func parseScoringRules(value string) *ScoringRules {
+	rules := defaultScoringRules()
+	if len(value) == 0 {
+		return rules
+	}
+	err := json.Unmarshal([]byte(value), rules)
+	if err != nil {
+		log.Error("Could not parse scoring rules: ", err)
+		return defaultScoringRules()
+	}
+	return rules
+}
+
+func (rules *ScoringRules) String() string {
+	b, _ := json.Marshal(rules)
+	return string(b)
+}
+
+func (rules *ScoringRules) validate() error {
+	values := []struct {
+		name  string
+		value float64
+	}{
+		{"correct", rules.Correct},
+		{"wrong", rules.Wrong},
+		{"speedBonus", rules.SpeedBonus},
+		{"partialAnswer", rules.PartialAnswer},
+	}
+	for _, v := range values {
+		if v.value < 0 {
+			return fmt.Errorf("%s can not be negative", v.name)
+		}
+	}
+	return nil
+}
+
+// scoreAnswers totals one team's answers question by question, in the order
+// the questions were answered.
+//
+// With partial credit a question with several correct answers is worth
+// Correct split evenly across them. The speed bonus is only given for a
+// question answered completely and without mistakes, and shrinks linearly
+// from SpeedBonus to zero over the game's time limit.
+//
+// FloorAtZero keeps the running total from going below zero after every
+// answer, the way games were always scored, so a wrong answer given while at
+// zero costs nothing. The per-question points are not floored.
+func scoreAnswers(rules *ScoringRules, seconds int, answers []*scoredAnswer) (float64, []*QuestionScore) {
+	scores := []*QuestionScore{}
+	byQuestion := map[string][]*scoredAnswer{}
+	for _, answer := range answers {
+		if _, ok := byQuestion[answer.questionID]; !ok {
+			scores = append(scores, &QuestionScore{QuestionID: answer.questionID})
+		}
+		byQuestion[answer.questionID] = append(byQuestion[answer.questionID], answer)
+	}
+
+	total := 0.0
+	for _, score := range scores {
+		correctCount := 0
+		elapsed := -1
+		for _, answer := range byQuestion[score.QuestionID] {
+			correctCount = answer.correctCount
+			if rules.IgnoreLate && answer.answer.Late {
+				continue
+			}
+			if answer.partial {
+				score.Partial++
+			} else if answer.answer.Status {
+				score.Correct++
+			} else {
+				score.Wrong++
+			}
+			if answer.elapsed > elapsed {
+				elapsed = answer.elapsed
+			}
+		}
+
+		correct := float64(score.Correct) + rules.PartialAnswer*float64(score.Partial)
+		complete := score.Correct > 0
+		if rules.PartialCredit && correctCount > 0 {
+			if correct > float64(correctCount) {
+				correct = float64(correctCount)
+			}
+			correct = correct / float64(correctCount)
+			complete = score.Correct >= correctCount
+		}
+		score.Points = round2(rules.Correct*correct - rules.Wrong*float64(score.Wrong))
+
+		if rules.SpeedBonus > 0 && seconds > 0 && complete && score.Wrong == 0 && score.Partial == 0 && elapsed >= 0 && elapsed < seconds {
+			score.Bonus = round2(rules.SpeedBonus * float64(seconds-elapsed) / float64(seconds))
+		}
+
+		total += score.Points + score.Bonus
+	}
+
+	if rules.FloorAtZero {
+		total = flooredTotal(rules, answers, scores)
+	}
+	return round2(total), scores
+}
+
+// flooredTotal adds up the answers one at a time, in the order they were
+// given, never letting the total go below zero. Each question's speed bonus
+// is added with its last answer.
+func flooredTotal(rules *ScoringRules, answers []*scoredAnswer, scores []*QuestionScore) float64 {
+	bonus := map[string]float64{}
+	for _, score := range scores {
+		bonus[score.QuestionID] = score.Bonus
+	}
+	last := map[string]int{}
+	for i, answer := range answers {
+		if rules.IgnoreLate && answer.answer.Late {
+			continue
+		}
+		last[answer.questionID] = i
+	}
+
+	total := 0.0
+	credited := map[string]float64{}
+	for i, answer := range answers {
+		if rules.IgnoreLate && answer.answer.Late {
+			continue
+		}
+
+		if answer.partial || answer.answer.Status {
+			worth := 1.0
+			if answer.partial {
+				worth = rules.PartialAnswer
+			}
+			if rules.PartialCredit && answer.correctCount > 0 {
+				worth = math.Min(worth/float64(answer.correctCount), 1-credited[answer.questionID])
+				credited[answer.questionID] += worth
+			}
+			total += rules.Correct * worth
+		} else {
+			total -= rules.Wrong
+		}
+		if total < 0 {
+			total = 0
+		}
+
+		if last[answer.questionID] == i {
+			total += bonus[answer.questionID]
+		}
+	}
+	return total
+}
+
+func round2(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// scoreTeams loads the answers of the given teams in the game and fills in
+// their answers, points and per-question scores using the game's rules.
+func scoreTeams(conn *Conn, game *Game, teams []*Team) error {
+	answers, err := getScoredAnswers(conn, game.ID)
+	if err != nil {
+		return err
+	}
+	scoreTeamAnswers(game, teams, answers)
+	return nil
+}
+
+// getScoredAnswers loads every team answer in the game by team, in the order
+// they were given. Rulings overturned on appeal replace the answer's status.
+// Typed responses count once graded; each one answers the whole question, so
+// only a team's first correct response to a question is scored.
+func getScoredAnswers(conn *Conn, gameID string) (map[string][]*scoredAnswer, error) {
+	rows, err := conn.Query(`
+		select ta.team_id, a.question_id, a.id, a.answer, coalesce(ta.ruling, a.status) = 1, ta.id, ta.late = 1,
+			coalesce(timestampdiff(second, gq.started, ta.created), -1),
+			(select count(*) from pbe.answers ca where ca.question_id = a.question_id and ca.status = 1),
+			0, ta.created, 0
+		from pbe.team_answers ta
+		inner join pbe.answers a on a.id = ta.answer_id
+		left join pbe.game_questions gq on gq.id = ta.game_question_id
+		where ta.game_id = ?
+		union all
+		select tr.team_id, gq.question_id, coalesce(tr.answer_id, ''), tr.response, tr.status = 'CORRECT', tr.id, tr.late = 1,
+			coalesce(timestampdiff(second, gq.started, tr.created), -1),
+			1,
+			tr.status = 'PARTIAL', tr.created, 1
+		from pbe.team_responses tr
+		inner join pbe.game_questions gq on gq.id = tr.game_question_id
+		where tr.game_id = ? and tr.status <> 'PENDING'
+		order by 11, 6
+	`, gameID, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	answers := map[string][]*scoredAnswer{}
+	for rows.Next() {
+		var created string
+		answer := &scoredAnswer{answer: &Answer{}}
+		err = rows.Scan(&answer.teamID, &answer.questionID, &answer.answer.ID, &answer.answer.Answer,
+			&answer.answer.Status, &answer.answer.TeamAnswerID, &answer.answer.Late, &answer.elapsed, &answer.correctCount,
+			&answer.partial, &created, &answer.response)
+		if err != nil {
+			return nil, err
+		}
+		answers[answer.teamID] = append(answers[answer.teamID], answer)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for teamID, teamAnswers := range answers {
+		answers[teamID] = firstCorrectResponses(teamAnswers)
+	}
+	return answers, nil
+}
+
+// firstCorrectResponses drops every correct typed response to a question
+// after the first, so a team that gets several spellings of one answer
+// accepted scores the question once.
+func firstCorrectResponses(answers []*scoredAnswer) []*scoredAnswer {
+	correct := map[string]bool{}
+	kept := []*scoredAnswer{}
+	for _, answer := range answers {
+		if answer.response && answer.answer.Status && !answer.partial {
+			if correct[answer.questionID] {
+				continue
+			}
+			correct[answer.questionID] = true
+		}
+		kept = append(kept, answer)
+	}
+	return kept
+}
+
+// scoreTeamAnswers fills in the teams' answers, points and per-question
+// scores from answers loaded by getScoredAnswers.
+func scoreTeamAnswers(game *Game, teams []*Team, answers map[string][]*scoredAnswer) {
+	rules := game.Scoring
+	if rules == nil {
+		rules = defaultScoringRules()
+	}
+	for _, team := range teams {
+		team.Answers = []*Answer{}
+		for _, answer := range answers[team.ID] {
+			team.Answers = append(team.Answers, answer.answer)
+		}
+		team.Points, team.Scores = scoreAnswers(rules, game.Seconds, answers[team.ID])
+	}
+}
+
+func (s *Server) updateScoringController(c echo.Context) error {
+	gameID := c.Param("gameID")
+	rules := defaultScoringRules()
+	err := c.Bind(rules)
+	if err != nil {
+		log.Error("Could not parse scoring rules: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse scoring rules: "+err.Error())
+	}
+	err = rules.validate()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	err = s.store.UpdateScoring(c.Request().Context(), gameID, rules)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, "Game not found: "+gameID)
+	}
+	if err != nil {
+		log.Error("Could not update scoring rules: ", gameID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not update scoring rules: "+gameID+" : "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, rules)
+}
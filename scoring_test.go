@@ -0,0 +1,114 @@
+package main
+
+import "testing"
+
+func testAnswer(questionID string, correct bool) *scoredAnswer {
+	return &scoredAnswer{
+		answer:       &Answer{Status: correct},
+		questionID:   questionID,
+		correctCount: 1,
+		elapsed:      -1,
+	}
+}
+
+func TestScoreAnswersDefaultRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		answers []*scoredAnswer
+		want    float64
+	}{
+		{
+			name: "no answers",
+			want: 0,
+		},
+		{
+			name:    "wrong answers at zero cost nothing",
+			answers: []*scoredAnswer{testAnswer("q1", false), testAnswer("q1", true)},
+			want:    1,
+		},
+		{
+			name:    "wrong answer after a correct one",
+			answers: []*scoredAnswer{testAnswer("q1", true), testAnswer("q2", false), testAnswer("q2", false), testAnswer("q3", true)},
+			want:    1,
+		},
+		{
+			name:    "questions answered out of turn",
+			answers: []*scoredAnswer{testAnswer("q1", false), testAnswer("q2", true), testAnswer("q1", true), testAnswer("q2", false)},
+			want:    1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The scoring used before rules existed: a point per correct
+			// answer and one off per wrong answer while above zero.
+			old := 0
+			for _, answer := range test.answers {
+				if answer.answer.Status {
+					old++
+				} else if old > 0 {
+					old--
+				}
+			}
+			if float64(old) != test.want {
+				t.Fatalf("old scoring gave %d, want %v", old, test.want)
+			}
+
+			total, _ := scoreAnswers(defaultScoringRules(), 30, test.answers)
+			if total != test.want {
+				t.Errorf("scored %v, want %v", total, test.want)
+			}
+		})
+	}
+}
+
+func TestScoreAnswersWithoutFloor(t *testing.T) {
+	rules := defaultScoringRules()
+	rules.FloorAtZero = false
+
+	total, scores := scoreAnswers(rules, 30, []*scoredAnswer{testAnswer("q1", false), testAnswer("q1", true), testAnswer("q2", false)})
+	if total != -1 {
+		t.Errorf("scored %v, want -1", total)
+	}
+	if len(scores) != 2 || scores[0].Points != 0 || scores[1].Points != -1 {
+		t.Errorf("unexpected question scores: %+v %+v", scores[0], scores[1])
+	}
+}
+
+func TestScoringRulesValidate(t *testing.T) {
+	rules := defaultScoringRules()
+	if err := rules.validate(); err != nil {
+		t.Errorf("default rules are invalid: %v", err)
+	}
+
+	rules.Wrong = -1
+	if err := rules.validate(); err == nil {
+		t.Errorf("negative wrong answer points were accepted")
+	}
+}
+
+func TestScoreAnswersAcceptedResponseVariants(t *testing.T) {
+	response := func(text string, correct bool) *scoredAnswer {
+		answer := testAnswer("q1", correct)
+		answer.answer.Answer = text
+		answer.response = true
+		return answer
+	}
+	answers := firstCorrectResponses([]*scoredAnswer{
+		response("Jordan", true),
+		response("The Jordan river", true),
+		response("Nile", false),
+		testAnswer("q2", true),
+	})
+	if len(answers) != 3 {
+		t.Fatalf("kept %d answers, want 3", len(answers))
+	}
+
+	total, scores := scoreAnswers(defaultScoringRules(), 30, answers)
+	if total != 1 {
+		t.Errorf("scored %v, want 1", total)
+	}
+	if scores[0].Correct != 1 || scores[0].Points != 0 {
+		t.Errorf("first question scored %+v, want one correct and one wrong", scores[0])
+	}
+}
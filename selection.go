@@ -0,0 +1,285 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+	"github.com/spf13/viper"
+)
+
+// defaultRecentGames is how many of a team's previous games are checked for
+// repeated questions when pbe.recentGames is not configured.
+const defaultRecentGames = 3
+
+// selectGameQuestions picks game.Questions questions from the game's chapters.
+// Chapters take turns so each one is represented, and questions used in the
+// recent games of any of the game's teams are only picked once a chapter has
+// no fresh questions left. A game without a question count uses every
+// question from its chapters.
+//
+// The order only depends on the game's seed, the question bank and the
+// previous games, so starting a copy of a game with the same seed against the
+// same data gives the same questions in the same order.
+func selectGameQuestions(conn *Conn, game *Game) ([]*Question, error) {
+	chapters := [][]*Question{}
+	for _, chapter := range game.Chapters {
+		questions, err := getChapterQuestions(conn, chapter)
+		if err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, questions)
+	}
+
+	recentGames := defaultRecentGames
+	if viper.IsSet("pbe.recentGames") {
+		recentGames = viper.GetInt("pbe.recentGames")
+	}
+	recent, err := getRecentQuestions(conn, game.ID, recentGames)
+	if err != nil {
+		return nil, err
+	}
+
+	r := rand.New(rand.NewSource(game.Seed))
+	return pickQuestions(r, chapters, recent, game.Questions), nil
+}
+
+func getChapterQuestions(conn *Conn, chapter *GameChapter) ([]*Question, error) {
+	rows, err := conn.Query(`
+		select id, book, chapter, verses, question
+		from pbe.questions
+		where book = ? and chapter = ?
+		order by verses, id
+	`, chapter.Book, chapter.Chapter)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	questions := []*Question{}
+	for rows.Next() {
+		question := &Question{}
+		err = rows.Scan(&question.ID, &question.Book, &question.Chapter, &question.Verses, &question.Question)
+		if err != nil {
+			return nil, err
+		}
+		questions = append(questions, question)
+	}
+	return questions, rows.Err()
+}
+
+// getRecentQuestions returns the questions asked in the last games played by
+// the clubs in this game, or by any team sharing a player with one of this
+// game's teams, so teams without a club are matched by who plays in them.
+// Only games created before this one count, so the selection for a game does
+// not change as later games are played.
+func getRecentQuestions(conn *Conn, gameID string, games int) (map[string]bool, error) {
+	recent := map[string]bool{}
+	if games <= 0 {
+		return recent, nil
+	}
+
+	rows, err := conn.Query(`
+		select distinct gq.question_id
+		from pbe.game_questions gq
+		inner join (
+			select distinct g.id, g.created
+			from pbe.games g
+			inner join pbe.teams t on t.game_id = g.id
+			where (
+				t.club_id in (select club_id from pbe.teams where game_id = ? and club_id is not null)
+				or exists (
+					select 1
+					from `+teamMembers+` ptm
+					inner join `+teamMembers+` gtm on gtm.user_id = ptm.user_id
+					inner join pbe.teams ct on ct.id = gtm.team_id
+					where ptm.team_id = t.id and ct.game_id = ?
+				)
+			)
+			and g.id <> ?
+			and g.created < (select created from pbe.games where id = ?)
+			order by g.created desc
+			limit ?
+		) recent on recent.id = gq.game_id
+	`, gameID, gameID, gameID, gameID, games)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		recent[id] = true
+	}
+	return recent, rows.Err()
+}
+
+// pickQuestions shuffles each chapter with fresh questions ahead of recent
+// ones, then takes one question per chapter in turn until n are picked. The
+// picked questions are shuffled again so chapters are not asked in rotation.
+func pickQuestions(r *rand.Rand, chapters [][]*Question, recent map[string]bool, n int) []*Question {
+	total := 0
+	queues := make([][]*Question, len(chapters))
+	for i, chapter := range chapters {
+		fresh := []*Question{}
+		used := []*Question{}
+		for _, question := range chapter {
+			if recent[question.ID] {
+				used = append(used, question)
+			} else {
+				fresh = append(fresh, question)
+			}
+		}
+		shuffleQuestions(r, fresh)
+		shuffleQuestions(r, used)
+		queues[i] = append(fresh, used...)
+		total += len(queues[i])
+	}
+	if n <= 0 || n > total {
+		n = total
+	}
+
+	picked := []*Question{}
+	seen := map[string]bool{}
+	for len(picked) < n {
+		for i := range queues {
+			if len(picked) == n {
+				break
+			}
+			for len(queues[i]) > 0 {
+				question := queues[i][0]
+				queues[i] = queues[i][1:]
+				if !seen[question.ID] {
+					seen[question.ID] = true
+					picked = append(picked, question)
+					break
+				}
+			}
+		}
+		if !hasQuestions(queues) {
+			break
+		}
+	}
+
+	shuffleQuestions(r, picked)
+	return picked
+}
+
+func hasQuestions(queues [][]*Question) bool {
+	for _, queue := range queues {
+		if len(queue) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func shuffleQuestions(r *rand.Rand, questions []*Question) {
+	r.Shuffle(len(questions), func(i, j int) {
+		questions[i], questions[j] = questions[j], questions[i]
+	})
+}
+
+// newSeed returns a random non-zero seed for a new game.
+func newSeed() int64 {
+	var b [8]byte
+	_, err := crand.Read(b[:])
+	if err != nil {
+		return 1
+	}
+	seed := int64(binary.BigEndian.Uint64(b[:]) >> 1)
+	if seed == 0 {
+		seed = 1
+	}
+	return seed
+}
+
+// getGameQuestionsController returns the questions of a started game in the
+// order they are asked.
+func (s *Server) getGameQuestionsController(c echo.Context) error {
+	gameID := c.Param("gameID")
+
+	questions, err := getGameQuestions(s.db.WithContext(c.Request().Context()), gameID)
+	if err != nil {
+		log.Error("Could not get game questions: ", gameID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get game questions: "+gameID+" : "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, questions)
+}
+
+// GameReplay struct
+type GameReplay struct {
+	Questions []*Question `json:"questions"`
+	Matches   bool        `json:"matches"`
+}
+
+// replayGameQuestionsController selects the game's questions again from its
+// seed, to check a started game's order can be reproduced or preview an open
+// game's. Matches says whether they are the questions the game was started
+// with, and is false for a game that has not started.
+func (s *Server) replayGameQuestionsController(c echo.Context) error {
+	gameID := c.Param("gameID")
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	game, err := s.store.Game(c.Request().Context(), gameID)
+	if err != nil {
+		log.Error("Could not get game: ", gameID, " : ", err)
+		return c.JSON(gameErrorStatus(err), "Could not get game: "+gameID+" : "+err.Error())
+	}
+
+	questions, err := selectGameQuestions(conn, game)
+	if err != nil {
+		log.Error("Could not select questions: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not select questions: "+err.Error())
+	}
+
+	asked, err := getGameQuestions(conn, gameID)
+	if err != nil {
+		log.Error("Could not get game questions: ", gameID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get game questions: "+gameID+" : "+err.Error())
+	}
+
+	replay := &GameReplay{Questions: questions, Matches: len(asked) > 0 && len(asked) == len(questions)}
+	for i := range asked {
+		if !replay.Matches {
+			break
+		}
+		replay.Matches = asked[i].ID == questions[i].ID
+	}
+
+	return c.JSON(http.StatusOK, replay)
+}
+
+func getGameQuestions(conn *Conn, gameID string) ([]*Question, error) {
+	rows, err := conn.Query(`
+		select q.id, q.book, q.chapter, q.verses, q.question
+		from pbe.game_questions gq
+		inner join pbe.questions q on q.id = gq.question_id
+		where gq.game_id = ?
+		order by gq.position
+	`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	questions := []*Question{}
+	for rows.Next() {
+		question := &Question{}
+		err = rows.Scan(&question.ID, &question.Book, &question.Chapter, &question.Verses, &question.Question)
+		if err != nil {
+			return nil, err
+		}
+		questions = append(questions, question)
+	}
+	return questions, rows.Err()
+}
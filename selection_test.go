@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func testChapter(book string, chapter, n int) []*Question {
+	questions := []*Question{}
+	for i := 1; i <= n; i++ {
+		questions = append(questions, &Question{
+			ID:      fmt.Sprintf("%s-%d-%d", book, chapter, i),
+			Book:    book,
+			Chapter: fmt.Sprint(chapter),
+			Verses:  fmt.Sprint(i),
+		})
+	}
+	return questions
+}
+
+func questionIDs(questions []*Question) []string {
+	ids := []string{}
+	for _, question := range questions {
+		ids = append(ids, question.ID)
+	}
+	return ids
+}
+
+func TestPickQuestions(t *testing.T) {
+	tests := []struct {
+		name     string
+		seed     int64
+		chapters [][]*Question
+		recent   map[string]bool
+		n        int
+		want     int
+		// perChapter is the fewest questions each chapter must get.
+		perChapter int
+		// fresh lists questions that must all be picked ahead of recent ones.
+		fresh []string
+	}{
+		{
+			name:       "every question when n is zero",
+			seed:       1,
+			chapters:   [][]*Question{testChapter("Daniel", 1, 4), testChapter("Daniel", 2, 3)},
+			n:          0,
+			want:       7,
+			perChapter: 3,
+		},
+		{
+			name:       "n larger than the bank",
+			seed:       2,
+			chapters:   [][]*Question{testChapter("Daniel", 1, 2)},
+			n:          10,
+			want:       2,
+			perChapter: 2,
+		},
+		{
+			name:       "chapters take turns",
+			seed:       3,
+			chapters:   [][]*Question{testChapter("Daniel", 1, 10), testChapter("Daniel", 2, 10), testChapter("Daniel", 3, 10)},
+			n:          6,
+			want:       6,
+			perChapter: 2,
+		},
+		{
+			name:     "fresh questions before recent ones",
+			seed:     4,
+			chapters: [][]*Question{testChapter("Jonah", 1, 4)},
+			recent:   map[string]bool{"Jonah-1-1": true, "Jonah-1-2": true},
+			n:        2,
+			want:     2,
+			fresh:    []string{"Jonah-1-3", "Jonah-1-4"},
+		},
+		{
+			name:     "recent questions once a chapter runs out",
+			seed:     5,
+			chapters: [][]*Question{testChapter("Jonah", 1, 3)},
+			recent:   map[string]bool{"Jonah-1-1": true, "Jonah-1-2": true},
+			n:        3,
+			want:     3,
+			fresh:    []string{"Jonah-1-3"},
+		},
+		{
+			name:       "a question in two chapters is picked once",
+			seed:       6,
+			chapters:   [][]*Question{testChapter("Ruth", 1, 2), append(testChapter("Ruth", 1, 1), testChapter("Ruth", 2, 1)...)},
+			n:          0,
+			want:       3,
+			perChapter: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			picked := pickQuestions(rand.New(rand.NewSource(test.seed)), test.chapters, test.recent, test.n)
+			if len(picked) != test.want {
+				t.Fatalf("picked %d questions, want %d: %v", len(picked), test.want, questionIDs(picked))
+			}
+
+			seen := map[string]bool{}
+			for _, question := range picked {
+				if seen[question.ID] {
+					t.Errorf("picked %s twice", question.ID)
+				}
+				seen[question.ID] = true
+			}
+
+			for i, chapter := range test.chapters {
+				count := 0
+				for _, question := range chapter {
+					if seen[question.ID] {
+						count++
+					}
+				}
+				if count < test.perChapter {
+					t.Errorf("chapter %d got %d questions, want at least %d", i, count, test.perChapter)
+				}
+			}
+
+			for _, id := range test.fresh {
+				if !seen[id] {
+					t.Errorf("fresh question %s was not picked: %v", id, questionIDs(picked))
+				}
+			}
+
+			again := pickQuestions(rand.New(rand.NewSource(test.seed)), test.chapters, test.recent, test.n)
+			if fmt.Sprint(questionIDs(again)) != fmt.Sprint(questionIDs(picked)) {
+				t.Errorf("seed %d gave %v then %v", test.seed, questionIDs(picked), questionIDs(again))
+			}
+		})
+	}
+}
+
+func TestPickQuestionsSeedChangesOrder(t *testing.T) {
+	chapters := [][]*Question{testChapter("Esther", 1, 20)}
+
+	orders := map[string]bool{}
+	for seed := int64(1); seed <= 5; seed++ {
+		picked := pickQuestions(rand.New(rand.NewSource(seed)), chapters, nil, 0)
+		orders[fmt.Sprint(questionIDs(picked))] = true
+	}
+	if len(orders) < 2 {
+		t.Errorf("five seeds gave the same order")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+// Store keeps the question bank, games, their teams, rosters and users, and
+// plays the games. Lookups of something that does not exist return
+// sql.ErrNoRows whatever the backend. Changing a question or answer that does
+// not exist, using a team under a game it is not in or adding a user that does
+// not exist returns a *NotFoundError, and gameplay that breaks the game's
+// status or answer rules returns a *StatusError, *TransitionError or
+// *AnswerRuleError.
+type Store interface {
+	Questions(ctx context.Context, filter *QuestionFilter) ([]*Question, int, error)
+	Question(ctx context.Context, questionID string) (*Question, error)
+	AddQuestion(ctx context.Context, question *Question) error
+	UpdateQuestion(ctx context.Context, question *Question) error
+	PatchQuestion(ctx context.Context, questionID string, patch *QuestionPatch) error
+	DeleteQuestion(ctx context.Context, questionID string) error
+	AddAnswer(ctx context.Context, questionID string, answer *Answer) error
+	PatchAnswer(ctx context.Context, questionID, answerID string, patch *AnswerPatch) (*Answer, error)
+	DeleteAnswer(ctx context.Context, answerID string) error
+
+	Games(ctx context.Context) ([]*Game, error)
+	Game(ctx context.Context, gameID string) (*Game, error)
+	AddGame(ctx context.Context, game *Game, userID string) error
+	DeleteGame(ctx context.Context, gameID string) error
+	AddTeam(ctx context.Context, gameID string, team *Team) error
+	UpdateScoring(ctx context.Context, gameID string, rules *ScoringRules) error
+	UpdateAnswering(ctx context.Context, gameID string, rules *AnswerRules) error
+	TeamMembers(ctx context.Context, gameID, teamID string) ([]*TeamMember, error)
+	AddTeamMember(ctx context.Context, gameID, teamID string, member *TeamMember) error
+	DeleteTeamMember(ctx context.Context, gameID, teamID, userID string) error
+	TeamMember(ctx context.Context, teamID, email string) (bool, error)
+	UserTeam(ctx context.Context, gameID, email string) (string, error)
+
+	StartGame(ctx context.Context, gameID, userID string) ([]*Question, error)
+	TransitionGame(ctx context.Context, gameID, to, userID string, from ...string) error
+	NextQuestion(ctx context.Context, gameID, userID string) error
+	PreviousQuestion(ctx context.Context, gameID string) error
+	CurrentQuestion(ctx context.Context, gameID string) (*Question, error)
+	GameTimer(ctx context.Context, gameID string) (*GameTimer, error)
+	TimedGames(ctx context.Context) ([]string, error)
+	AdvanceExpiredQuestion(ctx context.Context, gameID, gameQuestionID string) (bool, error)
+	AddTeamAnswer(ctx context.Context, gameID, teamID, answerID string) (string, error)
+	DeleteTeamAnswer(ctx context.Context, gameID, teamID, answerID string) error
+	LockTeamAnswers(ctx context.Context, gameID, teamID, userID string) error
+	Scoreboard(ctx context.Context, gameID string, players bool) (*Scoreboard, error)
+	ScoreTeams(ctx context.Context, game *Game, teams []*Team) error
+
+	User(ctx context.Context, email string) (*User, error)
+	AddUser(ctx context.Context, user *User) error
+	UpdateUser(ctx context.Context, user *User) error
+	Roles(ctx context.Context) ([]string, error)
+}
+
+// openStore opens the store named by database.driver. "mysql", the default,
+// uses the database at database.url. "memory" keeps everything in the process,
+// so the server runs standalone with no database; only the routes backed by
+// the store work then, and everything is lost on restart.
+func openStore() (Store, *DB, error) {
+	viper.SetDefault("database.driver", "mysql")
+
+	switch driver := viper.GetString("database.driver"); driver {
+	case "mysql":
+		db, err := openDB()
+		if err != nil {
+			return nil, nil, err
+		}
+		return &mysqlStore{db: db}, db, nil
+	case "memory":
+		return newMemoryStore(), nil, nil
+	default:
+		return nil, nil, fmt.Errorf("Unknown database driver: %s", driver)
+	}
+}
+
+// requireMySQL turns away requests to routes that still query MySQL directly
+// when the server runs on another store.
+func (s *Server) requireMySQL(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.db == nil {
+			return c.JSON(http.StatusServiceUnavailable, "This needs the mysql database driver")
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,1149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore is a Store held in the process. Everything it returns is a copy,
+// so callers can change what they get without touching the store. members
+// maps each team ID to the roles of its members by user ID.
+type memoryStore struct {
+	mu        sync.Mutex
+	questions map[string]*Question
+	games     map[string]*Game
+	plays     map[string]*memoryPlay
+	users     map[string]*User
+	members   map[string]map[string]string
+	roles     []string
+	now       func() time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		questions: map[string]*Question{},
+		games:     map[string]*Game{},
+		plays:     map[string]*memoryPlay{},
+		users:     map[string]*User{},
+		members:   map[string]map[string]string{},
+		roles:     []string{RoleAdmin, RoleCounselor, RolePathfinder, RoleParent},
+		now:       time.Now,
+	}
+}
+
+func (store *memoryStore) Questions(ctx context.Context, filter *QuestionFilter) ([]*Question, int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	matches := []*Question{}
+	for _, question := range store.questions {
+		if filter.matches(question) {
+			matches = append(matches, question)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Book != b.Book {
+			return a.Book < b.Book
+		}
+		if a.Chapter != b.Chapter {
+			return a.Chapter < b.Chapter
+		}
+		if a.Verses != b.Verses {
+			return a.Verses < b.Verses
+		}
+		return a.ID < b.ID
+	})
+
+	total := len(matches)
+	if filter.Offset < len(matches) {
+		matches = matches[filter.Offset:]
+	} else {
+		matches = nil
+	}
+	if filter.Limit > 0 && filter.Limit < len(matches) {
+		matches = matches[:filter.Limit]
+	}
+
+	questions := []*Question{}
+	for _, question := range matches {
+		questions = append(questions, copyQuestion(question))
+	}
+	return questions, total, nil
+}
+
+// matches is the in-memory version of where.
+func (filter *QuestionFilter) matches(question *Question) bool {
+	if len(filter.Book) > 0 && question.Book != filter.Book {
+		return false
+	}
+	if len(filter.Chapter) > 0 && question.Chapter != filter.Chapter {
+		return false
+	}
+	parts := strings.Split(question.Verses, "-")
+	if filter.VerseFrom > 0 && leadingNumber(parts[len(parts)-1]) < filter.VerseFrom {
+		return false
+	}
+	if filter.VerseTo > 0 && leadingNumber(parts[0]) > filter.VerseTo {
+		return false
+	}
+	if len(filter.Search) > 0 {
+		search := strings.ToLower(filter.Search)
+		if strings.Contains(strings.ToLower(question.Question), search) {
+			return true
+		}
+		for _, answer := range question.Answers {
+			if strings.Contains(strings.ToLower(answer.Answer), search) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// leadingNumber reads the number at the start of s the way MySQL casts text
+// to a number, so "12a" is 12 and "a" is 0.
+func leadingNumber(s string) int {
+	s = strings.TrimSpace(s)
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(s[:end])
+	return n
+}
+
+func (store *memoryStore) Question(ctx context.Context, questionID string) (*Question, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	question, ok := store.questions[questionID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyQuestion(question), nil
+}
+
+func (store *memoryStore) AddQuestion(ctx context.Context, question *Question) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	question.ID, _ = UUID()
+	for _, answer := range question.Answers {
+		answer.ID, _ = UUID()
+	}
+	store.questions[question.ID] = copyQuestion(question)
+	return nil
+}
+
+func (store *memoryStore) UpdateQuestion(ctx context.Context, question *Question) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	saved, ok := store.questions[question.ID]
+	if !ok {
+		return &NotFoundError{"Question", question.ID}
+	}
+	for _, answer := range question.Answers {
+		if len(answer.ID) > 0 && savedAnswer(saved, answer.ID) == nil {
+			return &NotFoundError{"Answer", answer.ID}
+		}
+	}
+
+	saved.Book = question.Book
+	saved.Chapter = question.Chapter
+	saved.Verses = question.Verses
+	saved.Question = question.Question
+	for _, answer := range question.Answers {
+		if len(answer.ID) == 0 {
+			answer.ID, _ = UUID()
+			saved.Answers = append(saved.Answers, &Answer{ID: answer.ID})
+		}
+		a := savedAnswer(saved, answer.ID)
+		a.Answer = answer.Answer
+		a.Status = answer.Status
+	}
+	sortAnswers(saved)
+	return nil
+}
+
+func (store *memoryStore) PatchQuestion(ctx context.Context, questionID string, patch *QuestionPatch) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	saved, ok := store.questions[questionID]
+	if !ok {
+		return &NotFoundError{"Question", questionID}
+	}
+	values := map[string]*string{
+		"book":     &saved.Book,
+		"chapter":  &saved.Chapter,
+		"verses":   &saved.Verses,
+		"question": &saved.Question,
+	}
+	for _, field := range patch.fields() {
+		if field.value != nil {
+			*values[field.name] = *field.value
+		}
+	}
+	return nil
+}
+
+func (store *memoryStore) DeleteQuestion(ctx context.Context, questionID string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.questions, questionID)
+	return nil
+}
+
+func (store *memoryStore) AddAnswer(ctx context.Context, questionID string, answer *Answer) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	question, ok := store.questions[questionID]
+	if !ok {
+		return fmt.Errorf("Question not found: %s", questionID)
+	}
+	answer.ID, _ = UUID()
+	question.Answers = append(question.Answers, &Answer{
+		ID:     answer.ID,
+		Answer: answer.Answer,
+		Status: answer.Status,
+	})
+	sortAnswers(question)
+	return nil
+}
+
+func (store *memoryStore) PatchAnswer(ctx context.Context, questionID, answerID string, patch *AnswerPatch) (*Answer, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var answer *Answer
+	question, ok := store.questions[questionID]
+	if ok {
+		answer = savedAnswer(question, answerID)
+	}
+	if answer == nil {
+		return nil, &NotFoundError{"Answer", answerID}
+	}
+	if patch.Answer != nil {
+		answer.Answer = *patch.Answer
+	}
+	if patch.Status != nil {
+		answer.Status = *patch.Status
+	}
+	sortAnswers(question)
+	return &Answer{ID: answer.ID, Answer: answer.Answer, Status: answer.Status}, nil
+}
+
+// savedAnswer returns the question's answer with the given ID, or nil.
+func savedAnswer(question *Question, answerID string) *Answer {
+	for _, answer := range question.Answers {
+		if answer.ID == answerID {
+			return answer
+		}
+	}
+	return nil
+}
+
+// sortAnswers keeps a question's answers in the order MySQL returns them.
+func sortAnswers(question *Question) {
+	sort.SliceStable(question.Answers, func(i, j int) bool {
+		return question.Answers[i].Answer < question.Answers[j].Answer
+	})
+}
+
+func (store *memoryStore) DeleteAnswer(ctx context.Context, answerID string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, question := range store.questions {
+		for i, answer := range question.Answers {
+			if answer.ID == answerID {
+				question.Answers = append(question.Answers[:i], question.Answers[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Games returns the first ten games by creation time, as the MySQL store does.
+func (store *memoryStore) Games(ctx context.Context) ([]*Game, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	games := []*Game{}
+	for _, game := range store.games {
+		games = append(games, copyGame(game))
+	}
+	sort.Slice(games, func(i, j int) bool {
+		if !games[i].Created.Equal(games[j].Created) {
+			return games[i].Created.Before(games[j].Created)
+		}
+		return games[i].ID < games[j].ID
+	})
+	if len(games) > 10 {
+		games = games[:10]
+	}
+	return games, nil
+}
+
+func (store *memoryStore) Game(ctx context.Context, gameID string) (*Game, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	game, ok := store.games[gameID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyGame(game), nil
+}
+
+// AddGame creates an open game with a Home team. Clubs live only in MySQL, so
+// games for clubs cannot be created here.
+func (store *memoryStore) AddGame(ctx context.Context, game *Game, userID string) error {
+	if len(game.ClubIDs) > 0 {
+		return fmt.Errorf("Could not create game: clubs need the mysql database driver")
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	game.ID, _ = UUID()
+	game.setDefaults()
+	game.Status = GameOpen
+	game.Created = store.now()
+
+	teamID, _ := UUID()
+	game.Teams = []*Team{{ID: teamID, Name: "Home"}}
+	for _, chapter := range game.Chapters {
+		chapter.ID, _ = UUID()
+	}
+
+	store.games[game.ID] = copyGame(game)
+	return nil
+}
+
+func (store *memoryStore) DeleteGame(ctx context.Context, gameID string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if game, ok := store.games[gameID]; ok {
+		for _, team := range game.Teams {
+			delete(store.members, team.ID)
+		}
+	}
+	delete(store.games, gameID)
+	delete(store.plays, gameID)
+	return nil
+}
+
+func (store *memoryStore) AddTeam(ctx context.Context, gameID string, team *Team) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	game, ok := store.games[gameID]
+	if !ok {
+		return fmt.Errorf("Game not found: %s", gameID)
+	}
+	team.ID, _ = UUID()
+	game.Teams = append(game.Teams, &Team{ID: team.ID, Name: team.Name})
+	sort.SliceStable(game.Teams, func(i, j int) bool {
+		return game.Teams[i].Name < game.Teams[j].Name
+	})
+	return nil
+}
+
+func (store *memoryStore) User(ctx context.Context, email string) (*User, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	user, ok := store.users[email]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyUser(user), nil
+}
+
+func (store *memoryStore) AddUser(ctx context.Context, user *User) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.users[user.Email]; ok {
+		return fmt.Errorf("User already exists: %s", user.Email)
+	}
+	user.ID, _ = UUID()
+	store.users[user.Email] = copyUser(user)
+	return nil
+}
+
+func (store *memoryStore) UpdateUser(ctx context.Context, user *User) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	saved, ok := store.users[user.Email]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	saved.FirstName = user.FirstName
+	saved.LastName = user.LastName
+	saved.Gender = user.Gender
+	saved.Birthdate = user.Birthdate
+	saved.Phone = user.Phone
+	for _, role := range user.Roles {
+		found := false
+		for _, r := range saved.Roles {
+			if r == role {
+				found = true
+				break
+			}
+		}
+		if !found {
+			saved.Roles = append(saved.Roles, role)
+		}
+	}
+	user.ID = saved.ID
+	return nil
+}
+
+func (store *memoryStore) Roles(ctx context.Context) ([]string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return append([]string{}, store.roles...), nil
+}
+
+func (store *memoryStore) UpdateScoring(ctx context.Context, gameID string, rules *ScoringRules) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	game, ok := store.games[gameID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	scoring := *rules
+	game.Scoring = &scoring
+	return nil
+}
+
+func (store *memoryStore) UpdateAnswering(ctx context.Context, gameID string, rules *AnswerRules) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	game, ok := store.games[gameID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	answering := *rules
+	game.Answering = &answering
+	return nil
+}
+
+// memoryPlay is a started game: its questions in the order they are asked,
+// which one is current, the answers the teams have given and the ones they
+// have locked in.
+type memoryPlay struct {
+	questions []*memoryGameQuestion
+	current   int
+	paused    time.Time
+	answers   []*memoryTeamAnswer
+	locks     map[memoryLock]bool
+}
+
+// memoryLock is a team's answers to a game question being locked in.
+type memoryLock struct {
+	gameQuestionID string
+	teamID         string
+}
+
+type memoryGameQuestion struct {
+	id         string
+	questionID string
+	started    time.Time
+}
+
+type memoryTeamAnswer struct {
+	id             string
+	gameQuestionID string
+	teamID         string
+	answerID       string
+	created        time.Time
+	late           bool
+}
+
+// TeamMembers lists the team's roster the way the MySQL store does. There are
+// no clubs here, so it is only the users added to the team.
+func (store *memoryStore) TeamMembers(ctx context.Context, gameID, teamID string) ([]*TeamMember, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	err := store.requireGameTeam(gameID, teamID)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []*TeamMember{}
+	for userID, role := range store.members[teamID] {
+		user := store.userByID(userID)
+		if user == nil {
+			continue
+		}
+		members = append(members, &TeamMember{
+			UserID:    user.ID,
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+			Email:     user.Email,
+			Role:      role,
+		})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		a, b := members[i], members[j]
+		if a.Role != b.Role {
+			return a.Role < b.Role
+		}
+		if a.LastName != b.LastName {
+			return a.LastName < b.LastName
+		}
+		return a.FirstName < b.FirstName
+	})
+	return members, nil
+}
+
+func (store *memoryStore) AddTeamMember(ctx context.Context, gameID, teamID string, member *TeamMember) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	err := store.requireGameTeam(gameID, teamID)
+	if err != nil {
+		return err
+	}
+
+	user := store.userByID(member.UserID)
+	if user == nil && len(member.Email) > 0 {
+		user = store.users[member.Email]
+	}
+	if user == nil {
+		return &NotFoundError{"User", member.lookup()}
+	}
+	member.UserID = user.ID
+	member.FirstName = user.FirstName
+	member.LastName = user.LastName
+	member.Email = user.Email
+
+	if store.members[teamID] == nil {
+		store.members[teamID] = map[string]string{}
+	}
+	store.members[teamID][user.ID] = member.Role
+	return nil
+}
+
+func (store *memoryStore) DeleteTeamMember(ctx context.Context, gameID, teamID, userID string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	err := store.requireGameTeam(gameID, teamID)
+	if err != nil {
+		return err
+	}
+	delete(store.members[teamID], userID)
+	return nil
+}
+
+func (store *memoryStore) TeamMember(ctx context.Context, teamID, email string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.isTeamMember(teamID, email), nil
+}
+
+// UserTeam returns the user's team in the game, first by name, or "" if they
+// are not on one.
+func (store *memoryStore) UserTeam(ctx context.Context, gameID, email string) (string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	game, ok := store.games[gameID]
+	if !ok {
+		return "", nil
+	}
+	for _, team := range game.Teams {
+		if store.isTeamMember(team.ID, email) {
+			return team.ID, nil
+		}
+	}
+	return "", nil
+}
+
+func (store *memoryStore) isTeamMember(teamID, email string) bool {
+	user, ok := store.users[email]
+	if !ok {
+		return false
+	}
+	_, ok = store.members[teamID][user.ID]
+	return ok
+}
+
+// requireGameTeam fails with a *NotFoundError unless the team is in the game.
+func (store *memoryStore) requireGameTeam(gameID, teamID string) error {
+	game, ok := store.games[gameID]
+	if !ok || !hasTeam(game, teamID) {
+		return &NotFoundError{"Team", teamID}
+	}
+	return nil
+}
+
+// userByID returns the user with the ID, or nil.
+func (store *memoryStore) userByID(userID string) *User {
+	for _, user := range store.users {
+		if user.ID == userID {
+			return user
+		}
+	}
+	return nil
+}
+
+// StartGame picks the game's questions the way the MySQL store does. Teams
+// have no clubs or players here, so no question counts as recently asked.
+func (store *memoryStore) StartGame(ctx context.Context, gameID, userID string) ([]*Question, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	game, ok := store.games[gameID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if game.Status != GameOpen {
+		return nil, &StatusError{GameID: gameID, Status: game.Status, Expected: []string{GameOpen}}
+	}
+
+	chapters := [][]*Question{}
+	for _, chapter := range game.Chapters {
+		chapters = append(chapters, store.chapterQuestions(chapter))
+	}
+	questions := pickQuestions(rand.New(rand.NewSource(game.Seed)), chapters, nil, game.Questions)
+	if len(questions) == 0 {
+		return nil, errNoQuestions
+	}
+
+	err := store.transition(game, GameStarted)
+	if err != nil {
+		return nil, err
+	}
+
+	play := &memoryPlay{}
+	for _, question := range questions {
+		id, _ := UUID()
+		play.questions = append(play.questions, &memoryGameQuestion{id: id, questionID: question.ID})
+	}
+	play.questions[0].started = store.now()
+	store.plays[gameID] = play
+	return questions, nil
+}
+
+// chapterQuestions lists the chapter's questions without their answers, by
+// verses, as getChapterQuestions does.
+func (store *memoryStore) chapterQuestions(chapter *GameChapter) []*Question {
+	questions := []*Question{}
+	for _, question := range store.questions {
+		if question.Book == chapter.Book && question.Chapter == chapter.Chapter {
+			q := *question
+			q.Answers = nil
+			questions = append(questions, &q)
+		}
+	}
+	sort.Slice(questions, func(i, j int) bool {
+		if questions[i].Verses != questions[j].Verses {
+			return questions[i].Verses < questions[j].Verses
+		}
+		return questions[i].ID < questions[j].ID
+	})
+	return questions
+}
+
+func (store *memoryStore) TransitionGame(ctx context.Context, gameID, to, userID string, from ...string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	game, ok := store.games[gameID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if len(from) > 0 {
+		err := requireStatus(game, from...)
+		if err != nil {
+			return err
+		}
+	}
+	return store.transition(game, to)
+}
+
+// transition is transitionGame and pauseGameTimer for the memory store.
+func (store *memoryStore) transition(game *Game, to string) error {
+	from := game.Status
+	if !canTransition(from, to) {
+		return &TransitionError{GameID: game.ID, From: from, To: to}
+	}
+	game.Status = to
+
+	play, ok := store.plays[game.ID]
+	if !ok {
+		return nil
+	}
+	if to == GamePaused {
+		play.paused = store.now()
+	} else if from == GamePaused {
+		if question := play.currentQuestion(); question != nil {
+			question.started = question.started.Add(store.now().Sub(play.paused))
+		}
+		play.paused = time.Time{}
+	}
+	return nil
+}
+
+// requireStatus is requireGameStatus for the memory store.
+func requireStatus(game *Game, statuses ...string) error {
+	for _, status := range statuses {
+		if game.Status == status {
+			return nil
+		}
+	}
+	return &StatusError{GameID: game.ID, Status: game.Status, Expected: statuses}
+}
+
+func (play *memoryPlay) currentQuestion() *memoryGameQuestion {
+	if play.current < 0 || play.current >= len(play.questions) {
+		return nil
+	}
+	return play.questions[play.current]
+}
+
+// startedGame returns a game that must be started and its play.
+func (store *memoryStore) startedGame(gameID string) (*Game, *memoryPlay, error) {
+	game, ok := store.games[gameID]
+	if !ok {
+		return nil, nil, sql.ErrNoRows
+	}
+	err := requireStatus(game, GameStarted)
+	if err != nil {
+		return nil, nil, err
+	}
+	return game, store.plays[gameID], nil
+}
+
+func (store *memoryStore) NextQuestion(ctx context.Context, gameID, userID string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	game, play, err := store.startedGame(gameID)
+	if err != nil {
+		return err
+	}
+	return store.next(game, play)
+}
+
+// next moves on to the following question, or finishes the game after the
+// last one.
+func (store *memoryStore) next(game *Game, play *memoryPlay) error {
+	if play.current+1 >= len(play.questions) {
+		return store.transition(game, GameFinished)
+	}
+	play.current++
+	play.questions[play.current].started = store.now()
+	return nil
+}
+
+func (store *memoryStore) PreviousQuestion(ctx context.Context, gameID string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	_, play, err := store.startedGame(gameID)
+	if err != nil {
+		return err
+	}
+	if play.current == 0 {
+		return nil
+	}
+	play.current--
+	play.questions[play.current].started = store.now()
+	return nil
+}
+
+func (store *memoryStore) CurrentQuestion(ctx context.Context, gameID string) (*Question, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	game, ok := store.games[gameID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if game.Status == GameFinished || game.Status == GameCancelled {
+		return &Question{Finished: true}, nil
+	}
+
+	play, ok := store.plays[gameID]
+	if !ok {
+		return &Question{Question: "Waiting for game to start"}, nil
+	}
+	question, ok := store.questions[play.currentQuestion().questionID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyQuestion(question), nil
+}
+
+func (store *memoryStore) GameTimer(ctx context.Context, gameID string) (*GameTimer, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	game, ok := store.games[gameID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return store.timer(game), nil
+}
+
+// timer is getGameTimer for the memory store.
+func (store *memoryStore) timer(game *Game) *GameTimer {
+	timer := &GameTimer{
+		Type:        "timer",
+		GameID:      game.ID,
+		Status:      game.Status,
+		Seconds:     game.Seconds,
+		AutoAdvance: game.AutoAdvance,
+		RejectLate:  game.RejectLate,
+	}
+
+	play, ok := store.plays[game.ID]
+	if !ok {
+		return timer
+	}
+	question := play.currentQuestion()
+	timer.GameQuestionID = question.id
+	if timer.Seconds <= 0 {
+		return timer
+	}
+
+	now := store.now()
+	if !play.paused.IsZero() {
+		now = play.paused
+	}
+	timer.Remaining = timer.Seconds - int(now.Sub(question.started)/time.Second)
+	if timer.Remaining <= 0 {
+		timer.Remaining = 0
+		timer.Expired = true
+	}
+	return timer
+}
+
+func (store *memoryStore) TimedGames(ctx context.Context) ([]string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	gameIDs := []string{}
+	for id, game := range store.games {
+		if _, ok := store.plays[id]; ok && game.Status == GameStarted && game.Seconds > 0 {
+			gameIDs = append(gameIDs, id)
+		}
+	}
+	sort.Strings(gameIDs)
+	return gameIDs, nil
+}
+
+func (store *memoryStore) AdvanceExpiredQuestion(ctx context.Context, gameID, gameQuestionID string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	game, play, err := store.startedGame(gameID)
+	if err != nil {
+		return false, err
+	}
+	timer := store.timer(game)
+	if timer.GameQuestionID != gameQuestionID || !timer.Expired {
+		return false, nil
+	}
+	return true, store.next(game, play)
+}
+
+// AddTeamAnswer checks the answer against the game's answer rules as
+// checkTeamAnswer does.
+func (store *memoryStore) AddTeamAnswer(ctx context.Context, gameID, teamID, answerID string) (string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	game, play, err := store.startedGame(gameID)
+	if err != nil {
+		return "", err
+	}
+	if !hasTeam(game, teamID) {
+		return "", &NotFoundError{"Team", teamID}
+	}
+
+	timer := store.timer(game)
+	if timer.Expired && timer.RejectLate {
+		return "", &AnswerRuleError{"Time is up for the current question"}
+	}
+
+	rules := game.Answering
+	if rules == nil {
+		rules = defaultAnswerRules()
+	}
+	if rules.Mode == AnswerText {
+		return "", &AnswerRuleError{"This game takes typed responses"}
+	}
+
+	question := store.answerQuestion(answerID)
+	if question == nil {
+		return "", sql.ErrNoRows
+	}
+	current := play.currentQuestion()
+	if question.ID != current.questionID {
+		return "", &AnswerRuleError{"This answer is not for the current question"}
+	}
+
+	selected := 0
+	for _, answer := range play.answers {
+		if answer.gameQuestionID != current.id || answer.teamID != teamID {
+			continue
+		}
+		if answer.answerID == answerID {
+			return "", &AnswerRuleError{"This answer has already been submitted"}
+		}
+		selected++
+	}
+	if rules.LockIn && play.locks[memoryLock{current.id, teamID}] {
+		return "", &AnswerRuleError{"Answers to this question are locked in"}
+	}
+	if limit := rules.limit(); limit > 0 && selected >= limit {
+		if limit == 1 {
+			return "", &AnswerRuleError{"Only one answer can be selected for this question"}
+		}
+		return "", &AnswerRuleError{fmt.Sprintf("Only %d answers can be selected for this question", limit)}
+	}
+
+	id, _ := UUID()
+	play.answers = append(play.answers, &memoryTeamAnswer{
+		id:             id,
+		gameQuestionID: current.id,
+		teamID:         teamID,
+		answerID:       answerID,
+		created:        store.now(),
+		late:           timer.Expired,
+	})
+	return id, nil
+}
+
+// DeleteTeamAnswer takes back the team's answer to the current question,
+// unless the game locks answers in and the team has locked them or reached
+// its limit.
+func (store *memoryStore) DeleteTeamAnswer(ctx context.Context, gameID, teamID, answerID string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	game, play, err := store.startedGame(gameID)
+	if err != nil {
+		return err
+	}
+	if !hasTeam(game, teamID) {
+		return &NotFoundError{"Team", teamID}
+	}
+	current := play.currentQuestion()
+
+	rules := game.Answering
+	if rules == nil {
+		rules = defaultAnswerRules()
+	}
+	if rules.LockIn {
+		locked := play.locks[memoryLock{current.id, teamID}]
+		if !locked && rules.limit() > 0 {
+			selected := 0
+			for _, answer := range play.answers {
+				if answer.gameQuestionID == current.id && answer.teamID == teamID {
+					selected++
+				}
+			}
+			locked = selected >= rules.limit()
+		}
+		if locked {
+			return &AnswerRuleError{"Answers to this question are locked in"}
+		}
+	}
+
+	answers := []*memoryTeamAnswer{}
+	for _, answer := range play.answers {
+		if answer.gameQuestionID != current.id || answer.teamID != teamID || answer.answerID != answerID {
+			answers = append(answers, answer)
+		}
+	}
+	play.answers = answers
+	return nil
+}
+
+// LockTeamAnswers locks in the team's answers to the current question, as
+// lockTeamAnswers does.
+func (store *memoryStore) LockTeamAnswers(ctx context.Context, gameID, teamID, userID string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	game, play, err := store.startedGame(gameID)
+	if err != nil {
+		return err
+	}
+	if !hasTeam(game, teamID) {
+		return &NotFoundError{"Team", teamID}
+	}
+	if game.Answering == nil || !game.Answering.LockIn {
+		return &AnswerRuleError{"This game does not lock in answers"}
+	}
+	current := play.currentQuestion()
+	if current == nil {
+		return &AnswerRuleError{"The game has no current question"}
+	}
+
+	if play.locks == nil {
+		play.locks = map[memoryLock]bool{}
+	}
+	play.locks[memoryLock{current.id, teamID}] = true
+	return nil
+}
+
+func hasTeam(game *Game, teamID string) bool {
+	for _, team := range game.Teams {
+		if team.ID == teamID {
+			return true
+		}
+	}
+	return false
+}
+
+// answerQuestion returns the question the answer belongs to, or nil.
+func (store *memoryStore) answerQuestion(answerID string) *Question {
+	for _, question := range store.questions {
+		for _, answer := range question.Answers {
+			if answer.ID == answerID {
+				return question
+			}
+		}
+	}
+	return nil
+}
+
+func (store *memoryStore) Scoreboard(ctx context.Context, gameID string, players bool) (*Scoreboard, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	game, ok := store.games[gameID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	game = copyGame(game)
+
+	answers := map[string][]*scoredAnswer{}
+	var hidden string
+	if play, ok := store.plays[gameID]; ok {
+		answers = store.scoredAnswers(play)
+		if players && (game.Status == GameStarted || game.Status == GamePaused) {
+			hidden = play.currentQuestion().questionID
+		}
+	}
+	return newScoreboard(game, answers, hidden), nil
+}
+
+func (store *memoryStore) ScoreTeams(ctx context.Context, game *Game, teams []*Team) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	answers := map[string][]*scoredAnswer{}
+	if play, ok := store.plays[game.ID]; ok {
+		answers = store.scoredAnswers(play)
+	}
+	scoreTeamAnswers(game, teams, answers)
+	return nil
+}
+
+// scoredAnswers is getScoredAnswers for the memory store. Answers whose
+// question has since been deleted are left out.
+func (store *memoryStore) scoredAnswers(play *memoryPlay) map[string][]*scoredAnswer {
+	started := map[string]time.Time{}
+	for _, question := range play.questions {
+		started[question.id] = question.started
+	}
+
+	answers := map[string][]*scoredAnswer{}
+	for _, teamAnswer := range play.answers {
+		question := store.answerQuestion(teamAnswer.answerID)
+		if question == nil {
+			continue
+		}
+		scored := &scoredAnswer{
+			teamID:     teamAnswer.teamID,
+			questionID: question.ID,
+			elapsed:    int(teamAnswer.created.Sub(started[teamAnswer.gameQuestionID]) / time.Second),
+		}
+		for _, answer := range question.Answers {
+			if answer.Status {
+				scored.correctCount++
+			}
+			if answer.ID == teamAnswer.answerID {
+				scored.answer = &Answer{
+					ID:           answer.ID,
+					Answer:       answer.Answer,
+					Status:       answer.Status,
+					TeamAnswerID: teamAnswer.id,
+					Late:         teamAnswer.late,
+				}
+			}
+		}
+		answers[scored.teamID] = append(answers[scored.teamID], scored)
+	}
+	return answers
+}
+
+func copyQuestion(question *Question) *Question {
+	copied := *question
+	copied.Answers = nil
+	for _, answer := range question.Answers {
+		a := *answer
+		copied.Answers = append(copied.Answers, &a)
+	}
+	return &copied
+}
+
+func copyGame(game *Game) *Game {
+	copied := *game
+	copied.Chapters = nil
+	for _, chapter := range game.Chapters {
+		c := *chapter
+		copied.Chapters = append(copied.Chapters, &c)
+	}
+	copied.Teams = nil
+	for _, team := range game.Teams {
+		t := *team
+		copied.Teams = append(copied.Teams, &t)
+	}
+	if game.Scoring != nil {
+		scoring := *game.Scoring
+		copied.Scoring = &scoring
+	}
+	if game.Answering != nil {
+		answering := *game.Answering
+		copied.Answering = &answering
+	}
+	copied.ClubIDs = nil
+	return &copied
+}
+
+func copyUser(user *User) *User {
+	copied := *user
+	copied.Roles = append([]string{}, user.Roles...)
+	return &copied
+}
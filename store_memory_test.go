@@ -0,0 +1,601 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	melody "gopkg.in/olahol/melody.v1"
+)
+
+// testClock is a memory store clock the tests move by hand.
+type testClock struct {
+	now time.Time
+}
+
+func (clock *testClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *testClock) advance(d time.Duration) {
+	clock.now = clock.now.Add(d)
+}
+
+// newTestGame adds three questions on Ruth 1, each with two correct answers
+// and one wrong one, and a game on the chapter with a Home and an Away team.
+func newTestGame(t *testing.T, store *memoryStore, game *Game) (*Game, *testClock) {
+	clock := &testClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store.now = clock.Now
+
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		err := store.AddQuestion(ctx, &Question{
+			Book:     "Ruth",
+			Chapter:  "1",
+			Verses:   fmt.Sprint(i),
+			Question: fmt.Sprintf("Question %d", i),
+			Answers: []*Answer{
+				{Answer: "Right", Status: true},
+				{Answer: "Also right", Status: true},
+				{Answer: "Wrong", Status: false},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	game.Name = "Test"
+	game.Chapters = []*GameChapter{{Book: "Ruth", Chapter: "1"}}
+	err := store.AddGame(ctx, game, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddTeam(ctx, game.ID, &Team{Name: "Away"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	game, err = store.Game(ctx, game.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return game, clock
+}
+
+func teamID(game *Game, name string) string {
+	for _, team := range game.Teams {
+		if team.Name == name {
+			return team.ID
+		}
+	}
+	return ""
+}
+
+// answerID returns the ID of the current question's answer with the given
+// text.
+func answerID(t *testing.T, store *memoryStore, gameID, text string) string {
+	question, err := store.CurrentQuestion(context.Background(), gameID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, answer := range question.Answers {
+		if answer.Answer == text {
+			return answer.ID
+		}
+	}
+	t.Fatalf("current question %q has no answer %q", question.Question, text)
+	return ""
+}
+
+func scoreboardPoints(t *testing.T, store *memoryStore, gameID string, players bool) map[string]float64 {
+	scoreboard, err := store.Scoreboard(context.Background(), gameID, players)
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := map[string]float64{}
+	for _, team := range scoreboard.Teams {
+		points[team.Name] = team.Points
+	}
+	return points
+}
+
+func checkPoints(t *testing.T, name string, got map[string]float64, home, away float64) {
+	t.Helper()
+	if got["Home"] != home || got["Away"] != away {
+		t.Errorf("%s: Home %v Away %v, want %v and %v", name, got["Home"], got["Away"], home, away)
+	}
+}
+
+func TestMemoryStorePlaysGame(t *testing.T) {
+	store := newMemoryStore()
+	game, _ := newTestGame(t, store, &Game{})
+	ctx := context.Background()
+	home, away := teamID(game, "Home"), teamID(game, "Away")
+
+	question, err := store.CurrentQuestion(ctx, game.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if question.Question != "Waiting for game to start" {
+		t.Errorf("open game is asking %q", question.Question)
+	}
+
+	questions, err := store.StartGame(ctx, game.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(questions) != 3 {
+		t.Fatalf("started with %d questions, want 3", len(questions))
+	}
+	_, err = store.StartGame(ctx, game.ID, "")
+	if _, ok := err.(*StatusError); !ok {
+		t.Errorf("starting twice gave %v, want a *StatusError", err)
+	}
+
+	question, err = store.CurrentQuestion(ctx, game.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if question.ID != questions[0].ID {
+		t.Errorf("current question is %s, want the first one %s", question.ID, questions[0].ID)
+	}
+
+	_, err = store.AddTeamAnswer(ctx, game.ID, home, answerID(t, store, game.ID, "Right"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.AddTeamAnswer(ctx, game.ID, away, answerID(t, store, game.ID, "Wrong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPoints(t, "moderators while asking", scoreboardPoints(t, store, game.ID, false), 1, 0)
+	checkPoints(t, "players while asking", scoreboardPoints(t, store, game.ID, true), 0, 0)
+
+	err = store.NextQuestion(ctx, game.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPoints(t, "players after moving on", scoreboardPoints(t, store, game.ID, true), 1, 0)
+
+	_, err = store.AddTeamAnswer(ctx, game.ID, away, answerID(t, store, game.ID, "Right"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.PreviousQuestion(ctx, game.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	question, _ = store.CurrentQuestion(ctx, game.ID)
+	if question.ID != questions[0].ID {
+		t.Errorf("previous went to %s, want %s", question.ID, questions[0].ID)
+	}
+	err = store.PreviousQuestion(ctx, game.ID)
+	if err != nil {
+		t.Errorf("previous on the first question: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		err = store.NextQuestion(ctx, game.ID, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	question, _ = store.CurrentQuestion(ctx, game.ID)
+	if !question.Finished {
+		t.Errorf("game is not finished after the last question")
+	}
+	checkPoints(t, "finished", scoreboardPoints(t, store, game.ID, true), 1, 1)
+
+	err = store.NextQuestion(ctx, game.ID, "")
+	if _, ok := err.(*StatusError); !ok {
+		t.Errorf("next on a finished game gave %v, want a *StatusError", err)
+	}
+}
+
+func TestMemoryStoreAnswerRules(t *testing.T) {
+	store := newMemoryStore()
+	game, _ := newTestGame(t, store, &Game{Answering: &AnswerRules{Mode: AnswerSingle, LockIn: true}})
+	ctx := context.Background()
+	home := teamID(game, "Home")
+
+	_, err := store.AddTeamAnswer(ctx, game.ID, home, "answer")
+	if _, ok := err.(*StatusError); !ok {
+		t.Errorf("answering before the start gave %v, want a *StatusError", err)
+	}
+
+	questions, err := store.StartGame(ctx, game.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := store.Question(ctx, questions[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.AddTeamAnswer(ctx, game.ID, home, other.Answers[0].ID)
+	if _, ok := err.(*AnswerRuleError); !ok {
+		t.Errorf("answering another question gave %v, want an *AnswerRuleError", err)
+	}
+
+	right := answerID(t, store, game.ID, "Right")
+	_, err = store.AddTeamAnswer(ctx, game.ID, home, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.AddTeamAnswer(ctx, game.ID, home, right)
+	if _, ok := err.(*AnswerRuleError); !ok {
+		t.Errorf("answering twice gave %v, want an *AnswerRuleError", err)
+	}
+	_, err = store.AddTeamAnswer(ctx, game.ID, home, answerID(t, store, game.ID, "Also right"))
+	if _, ok := err.(*AnswerRuleError); !ok {
+		t.Errorf("a second single answer gave %v, want an *AnswerRuleError", err)
+	}
+	err = store.DeleteTeamAnswer(ctx, game.ID, home, right)
+	if _, ok := err.(*AnswerRuleError); !ok {
+		t.Errorf("taking back a locked in answer gave %v, want an *AnswerRuleError", err)
+	}
+
+	_, err = store.AddTeamAnswer(ctx, game.ID, "team", right)
+	if gameErrorStatus(err) != http.StatusNotFound {
+		t.Errorf("answering for a team not in the game gave %v, want a 404", err)
+	}
+}
+
+func TestMemoryStoreDeleteTeamAnswer(t *testing.T) {
+	store := newMemoryStore()
+	game, _ := newTestGame(t, store, &Game{})
+	ctx := context.Background()
+	home := teamID(game, "Home")
+
+	_, err := store.StartGame(ctx, game.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	right := answerID(t, store, game.ID, "Right")
+	_, err = store.AddTeamAnswer(ctx, game.ID, home, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPoints(t, "answered", scoreboardPoints(t, store, game.ID, false), 1, 0)
+
+	err = store.DeleteTeamAnswer(ctx, game.ID, home, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPoints(t, "taken back", scoreboardPoints(t, store, game.ID, false), 0, 0)
+
+	_, err = store.AddTeamAnswer(ctx, game.ID, home, right)
+	if err != nil {
+		t.Errorf("answering again after taking it back: %v", err)
+	}
+}
+
+func TestMemoryStoreLocksTeamAnswers(t *testing.T) {
+	store := newMemoryStore()
+	game, _ := newTestGame(t, store, &Game{Answering: &AnswerRules{Mode: AnswerMulti, LockIn: true}})
+	ctx := context.Background()
+	home, away := teamID(game, "Home"), teamID(game, "Away")
+
+	_, err := store.StartGame(ctx, game.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	right := answerID(t, store, game.ID, "Right")
+	_, err = store.AddTeamAnswer(ctx, game.ID, home, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.LockTeamAnswers(ctx, game.ID, home, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.AddTeamAnswer(ctx, game.ID, home, answerID(t, store, game.ID, "Also right"))
+	if _, ok := err.(*AnswerRuleError); !ok {
+		t.Errorf("answering after locking in gave %v, want an *AnswerRuleError", err)
+	}
+	err = store.DeleteTeamAnswer(ctx, game.ID, home, right)
+	if _, ok := err.(*AnswerRuleError); !ok {
+		t.Errorf("taking back a locked in answer gave %v, want an *AnswerRuleError", err)
+	}
+	_, err = store.AddTeamAnswer(ctx, game.ID, away, right)
+	if err != nil {
+		t.Errorf("another team could not answer: %v", err)
+	}
+
+	err = store.NextQuestion(ctx, game.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.AddTeamAnswer(ctx, game.ID, home, answerID(t, store, game.ID, "Right"))
+	if err != nil {
+		t.Errorf("answering the next question: %v", err)
+	}
+
+	err = store.UpdateAnswering(ctx, game.ID, &AnswerRules{Mode: AnswerMulti})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.LockTeamAnswers(ctx, game.ID, home, "")
+	if _, ok := err.(*AnswerRuleError); !ok {
+		t.Errorf("locking in without the rule gave %v, want an *AnswerRuleError", err)
+	}
+}
+
+func TestMemoryStoreTeamMembers(t *testing.T) {
+	store := newMemoryStore()
+	game, _ := newTestGame(t, store, &Game{})
+	other, _ := newTestGame(t, store, &Game{})
+	ctx := context.Background()
+	home, away := teamID(game, "Home"), teamID(game, "Away")
+
+	user := &User{FirstName: "Ruth", LastName: "Moab", Email: "ruth@example.com"}
+	err := store.AddUser(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	member := &TeamMember{Email: "ruth@example.com", Role: RolePathfinder}
+	err = store.AddTeamMember(ctx, game.ID, away, member)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if member.UserID != user.ID || member.FirstName != "Ruth" {
+		t.Errorf("added member is %+v, want %s", member, user.ID)
+	}
+	err = store.AddTeamMember(ctx, other.ID, home, &TeamMember{UserID: user.ID, Role: RolePathfinder})
+	if _, ok := err.(*NotFoundError); !ok {
+		t.Errorf("adding to another game's team gave %v, want a *NotFoundError", err)
+	}
+	err = store.AddTeamMember(ctx, game.ID, home, &TeamMember{Email: "naomi@example.com", Role: RolePathfinder})
+	if _, ok := err.(*NotFoundError); !ok {
+		t.Errorf("adding a missing user gave %v, want a *NotFoundError", err)
+	}
+
+	members, err := store.TeamMembers(ctx, game.ID, away)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].Email != "ruth@example.com" {
+		t.Errorf("away team has %+v, want Ruth", members)
+	}
+	onTeam, _ := store.TeamMember(ctx, away, "ruth@example.com")
+	if !onTeam {
+		t.Error("Ruth is not a member of the away team")
+	}
+	team, _ := store.UserTeam(ctx, game.ID, "ruth@example.com")
+	if team != away {
+		t.Errorf("Ruth's team is %q, want the away team", team)
+	}
+
+	err = store.DeleteTeamMember(ctx, game.ID, away, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	onTeam, _ = store.TeamMember(ctx, away, "ruth@example.com")
+	if onTeam {
+		t.Error("Ruth is still a member after being removed")
+	}
+}
+
+func TestMemoryStoreTimer(t *testing.T) {
+	store := newMemoryStore()
+	game, clock := newTestGame(t, store, &Game{Seconds: 30, RejectLate: true, AutoAdvance: true})
+	ctx := context.Background()
+	home := teamID(game, "Home")
+
+	_, err := store.StartGame(ctx, game.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timedGames, _ := store.TimedGames(ctx)
+	if len(timedGames) != 1 || timedGames[0] != game.ID {
+		t.Errorf("timed games are %v, want the started game", timedGames)
+	}
+
+	clock.advance(10 * time.Second)
+	err = store.TransitionGame(ctx, game.ID, GamePaused, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(time.Minute)
+	timer, _ := store.GameTimer(ctx, game.ID)
+	if timer.Remaining != 20 || timer.Expired {
+		t.Errorf("paused timer has %ds left (expired %v), want 20s", timer.Remaining, timer.Expired)
+	}
+
+	err = store.TransitionGame(ctx, game.ID, GameStarted, "", GamePaused)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timer, _ = store.GameTimer(ctx, game.ID)
+	if timer.Remaining != 20 {
+		t.Errorf("resumed timer has %ds left, want 20s", timer.Remaining)
+	}
+	err = store.TransitionGame(ctx, game.ID, GameStarted, "", GamePaused)
+	if _, ok := err.(*StatusError); !ok {
+		t.Errorf("resuming a started game gave %v, want a *StatusError", err)
+	}
+
+	clock.advance(25 * time.Second)
+	timer, _ = store.GameTimer(ctx, game.ID)
+	if !timer.Expired {
+		t.Fatalf("timer has %ds left, want it expired", timer.Remaining)
+	}
+	_, err = store.AddTeamAnswer(ctx, game.ID, home, answerID(t, store, game.ID, "Right"))
+	if _, ok := err.(*AnswerRuleError); !ok {
+		t.Errorf("answering late gave %v, want an *AnswerRuleError", err)
+	}
+
+	advanced, err := store.AdvanceExpiredQuestion(ctx, game.ID, "another question")
+	if err != nil || advanced {
+		t.Errorf("advanced from a question that is not current: %v %v", advanced, err)
+	}
+	advanced, err = store.AdvanceExpiredQuestion(ctx, game.ID, timer.GameQuestionID)
+	if err != nil || !advanced {
+		t.Fatalf("did not advance from the expired question: %v %v", advanced, err)
+	}
+	timer, _ = store.GameTimer(ctx, game.ID)
+	if timer.Remaining != 30 {
+		t.Errorf("next question has %ds left, want 30s", timer.Remaining)
+	}
+}
+
+// TestGameplayControllersWithMemoryStore plays through the HTTP handlers on a
+// server without MySQL.
+func TestGameplayControllersWithMemoryStore(t *testing.T) {
+	store := newMemoryStore()
+	game, _ := newTestGame(t, store, &Game{})
+	s := &Server{store: store, hub: melody.New(), bus: newEventBus()}
+	home := teamID(game, "Home")
+
+	e := echo.New()
+	call := func(handler echo.HandlerFunc, roles []interface{}, names []string, values ...string) int {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec)
+		c.SetParamNames(names...)
+		c.SetParamValues(values...)
+		c.Set("user", &jwt.Token{Claims: jwt.MapClaims{"user_id": "player@example.com", "scope": roles}})
+		err := handler(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code
+	}
+	staff := []interface{}{RoleCounselor}
+	player := []interface{}{RolePathfinder}
+	gameParam := []string{"gameID"}
+	answerParams := []string{"gameID", "teamID", "answerID"}
+
+	if code := call(s.startGameController, staff, gameParam, game.ID); code != http.StatusOK {
+		t.Fatalf("start gave %d", code)
+	}
+	if code := call(s.getCurrentQuestionController, player, gameParam, game.ID); code != http.StatusOK {
+		t.Errorf("current question gave %d", code)
+	}
+
+	right := answerID(t, store, game.ID, "Right")
+	if code := call(s.addTeamAnswerController, player, answerParams, game.ID, home, right); code != http.StatusForbidden {
+		t.Errorf("answer from a player not on the team gave %d, want 403", code)
+	}
+	err := store.AddUser(context.Background(), &User{FirstName: "Ruth", Email: "player@example.com"})
+	if err == nil {
+		err = store.AddTeamMember(context.Background(), game.ID, home, &TeamMember{Email: "player@example.com", Role: RolePathfinder})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := call(s.addTeamAnswerController, player, answerParams, game.ID, home, answerID(t, store, game.ID, "Also right")); code != http.StatusOK {
+		t.Errorf("answer from a team member gave %d", code)
+	}
+	if code := call(s.addTeamAnswerController, staff, answerParams, game.ID, home, right); code != http.StatusOK {
+		t.Errorf("answer gave %d", code)
+	}
+	if code := call(s.addTeamAnswerController, staff, answerParams, game.ID, home, right); code != http.StatusConflict {
+		t.Errorf("repeated answer gave %d, want 409", code)
+	}
+
+	other := &Game{Name: "Other", Chapters: game.Chapters}
+	err = store.AddGame(context.Background(), other, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := call(s.startGameController, staff, gameParam, other.ID); code != http.StatusOK {
+		t.Fatalf("starting the other game gave %d", code)
+	}
+	otherRight := answerID(t, store, other.ID, "Right")
+	if code := call(s.addTeamAnswerController, staff, answerParams, other.ID, home, otherRight); code != http.StatusNotFound {
+		t.Errorf("answer for another game's team gave %d, want 404", code)
+	}
+	if code := call(s.deleteTeamAnswerController, staff, answerParams, other.ID, home, otherRight); code != http.StatusNotFound {
+		t.Errorf("deleting for another game's team gave %d, want 404", code)
+	}
+	teamParams := []string{"gameID", "teamID"}
+	if code := call(s.getTeamMembersController, player, teamParams, game.ID, home); code != http.StatusOK {
+		t.Errorf("team members gave %d", code)
+	}
+	if code := call(s.getTeamMembersController, player, teamParams, other.ID, home); code != http.StatusNotFound {
+		t.Errorf("members of another game's team gave %d, want 404", code)
+	}
+	if code := call(s.lockTeamAnswersController, player, teamParams, game.ID, home); code != http.StatusConflict {
+		t.Errorf("locking in a game without the rule gave %d, want 409", code)
+	}
+
+	for _, handler := range []echo.HandlerFunc{s.nextQuestionController, s.previousQuestionController, s.pauseGameController, s.resumeGameController} {
+		if code := call(handler, staff, gameParam, game.ID); code != http.StatusOK {
+			t.Errorf("moving the game on gave %d", code)
+		}
+	}
+	if code := call(s.getGameTimerController, player, gameParam, game.ID); code != http.StatusOK {
+		t.Errorf("timer gave %d", code)
+	}
+	if code := call(s.getScoreboardController, player, gameParam, game.ID); code != http.StatusOK {
+		t.Errorf("scoreboard gave %d", code)
+	}
+	if code := call(s.finishGameController, staff, gameParam, game.ID); code != http.StatusOK {
+		t.Errorf("finish gave %d", code)
+	}
+	if code := call(s.cancelGameController, staff, gameParam, game.ID); code != http.StatusConflict {
+		t.Errorf("cancelling a finished game gave %d, want 409", code)
+	}
+	if code := call(s.nextQuestionController, staff, gameParam, "missing"); code != http.StatusNotFound {
+		t.Errorf("next on a missing game gave %d, want 404", code)
+	}
+	if code := call(s.getScoreboardController, player, gameParam, "missing"); code != http.StatusNotFound {
+		t.Errorf("scoreboard for a missing game gave %d, want 404", code)
+	}
+}
+
+func TestMemoryStoreUpdatesQuestion(t *testing.T) {
+	store := newMemoryStore()
+	ctx := context.Background()
+	question := &Question{Book: "Ruth", Chapter: "1", Verses: "1", Question: "Who?", Answers: []*Answer{{Answer: "Naomi", Status: true}}}
+	err := store.AddQuestion(ctx, question)
+	if err != nil {
+		t.Fatal(err)
+	}
+	naomi := question.Answers[0].ID
+
+	err = store.UpdateQuestion(ctx, &Question{ID: question.ID, Question: "Who?", Answers: []*Answer{{ID: "missing"}}})
+	if _, ok := err.(*NotFoundError); !ok {
+		t.Errorf("updating a missing answer gave %v, want a *NotFoundError", err)
+	}
+
+	err = store.UpdateQuestion(ctx, &Question{
+		ID: question.ID, Book: "Ruth", Chapter: "2", Verses: "1", Question: "Who went back?",
+		Answers: []*Answer{{ID: naomi, Answer: "Naomi", Status: true}, {Answer: "Orpah", Status: false}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verses := "1-2"
+	err = store.PatchQuestion(ctx, question.ID, &QuestionPatch{Verses: &verses})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := false
+	answer, err := store.PatchAnswer(ctx, question.ID, naomi, &AnswerPatch{Status: &status})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.Answer != "Naomi" || answer.Status {
+		t.Errorf("patched answer is %q %v, want Naomi false", answer.Answer, answer.Status)
+	}
+
+	saved, err := store.Question(ctx, question.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Chapter != "2" || saved.Verses != "1-2" || saved.Question != "Who went back?" || len(saved.Answers) != 2 {
+		t.Errorf("saved question is %+v with %d answers", saved, len(saved.Answers))
+	}
+
+	_, err = store.PatchAnswer(ctx, "missing", naomi, &AnswerPatch{Status: &status})
+	if _, ok := err.(*NotFoundError); !ok {
+		t.Errorf("patching an answer of a missing question gave %v, want a *NotFoundError", err)
+	}
+}
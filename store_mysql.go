@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// mysqlStore is the Store on the shared MySQL pool.
+type mysqlStore struct {
+	db *DB
+}
+
+func (store *mysqlStore) Questions(ctx context.Context, filter *QuestionFilter) ([]*Question, int, error) {
+	return getQuestions(store.db.WithContext(ctx), filter)
+}
+
+func (store *mysqlStore) Question(ctx context.Context, questionID string) (*Question, error) {
+	question, err := getQuestion(store.db.WithContext(ctx), questionID)
+	if err == nil && len(question.Book) == 0 && len(question.Question) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return question, err
+}
+
+func (store *mysqlStore) AddQuestion(ctx context.Context, question *Question) error {
+	tx, err := store.db.WithContext(ctx).Begin()
+	if err != nil {
+		return err
+	}
+
+	err = insertQuestion(tx, question)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *mysqlStore) UpdateQuestion(ctx context.Context, question *Question) error {
+	tx, err := store.db.WithContext(ctx).Begin()
+	if err != nil {
+		return err
+	}
+
+	err = updateQuestion(tx, question)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *mysqlStore) PatchQuestion(ctx context.Context, questionID string, patch *QuestionPatch) error {
+	tx, err := store.db.WithContext(ctx).Begin()
+	if err != nil {
+		return err
+	}
+
+	err = patchQuestion(tx, questionID, patch)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *mysqlStore) DeleteQuestion(ctx context.Context, questionID string) error {
+	_, err := store.db.WithContext(ctx).Exec(`
+		delete from pbe.questions where id = ?
+	`, questionID)
+	return err
+}
+
+func (store *mysqlStore) AddAnswer(ctx context.Context, questionID string, answer *Answer) error {
+	answer.ID, _ = UUID()
+	_, err := store.db.WithContext(ctx).Exec(`
+		insert into pbe.answers(id, answer, status, question_id)
+		values(?,?,?,?)
+	`, answer.ID, answer.Answer, answer.Status, questionID)
+	return err
+}
+
+func (store *mysqlStore) PatchAnswer(ctx context.Context, questionID, answerID string, patch *AnswerPatch) (*Answer, error) {
+	tx, err := store.db.WithContext(ctx).Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	answer, err := patchAnswer(tx, questionID, answerID, patch)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return answer, tx.Commit()
+}
+
+func (store *mysqlStore) DeleteAnswer(ctx context.Context, answerID string) error {
+	_, err := store.db.WithContext(ctx).Exec(`
+		delete from pbe.answers where id = ?
+	`, answerID)
+	return err
+}
+
+func (store *mysqlStore) Games(ctx context.Context) ([]*Game, error) {
+	return getGames(store.db.WithContext(ctx))
+}
+
+func (store *mysqlStore) Game(ctx context.Context, gameID string) (*Game, error) {
+	return requireGame(store.db.WithContext(ctx), gameID)
+}
+
+func (store *mysqlStore) AddGame(ctx context.Context, game *Game, userID string) error {
+	tx, err := store.db.WithContext(ctx).Begin()
+	if err != nil {
+		return err
+	}
+
+	err = insertGame(tx, game, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *mysqlStore) DeleteGame(ctx context.Context, gameID string) error {
+	_, err := store.db.WithContext(ctx).Exec(`
+		delete from pbe.games where id = ?
+	`, gameID)
+	return err
+}
+
+func (store *mysqlStore) AddTeam(ctx context.Context, gameID string, team *Team) error {
+	team.ID, _ = UUID()
+	_, err := store.db.WithContext(ctx).Exec(`
+		insert into pbe.teams(id, name, game_id)
+		values(?,?,?)
+	`, team.ID, team.Name, gameID)
+	return err
+}
+
+func (store *mysqlStore) User(ctx context.Context, email string) (*User, error) {
+	conn := store.db.WithContext(ctx)
+
+	user := &User{
+		Email: email,
+		Roles: []string{},
+	}
+	err := conn.QueryRow(`
+		select
+			id
+			, first_name
+			, last_name
+			, coalesce(gender, 'male')
+			, coalesce(image_url, '')
+		from users
+		where email = ?
+	`, email).Scan(&user.ID, &user.FirstName, &user.LastName, &user.Gender, &user.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(`
+		select role_id from user_roles where user_id = ?
+	`, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role string
+		err = rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+		user.Roles = append(user.Roles, role)
+	}
+	return user, rows.Err()
+}
+
+func (store *mysqlStore) AddUser(ctx context.Context, user *User) error {
+	user.ID, _ = UUID()
+	_, err := store.db.WithContext(ctx).Exec(`
+		insert into users(id, first_name, last_name, email, gender, image_url)
+		values(?,?,?,?,?,?)
+	`, user.ID, user.FirstName, user.LastName, user.Email, user.Gender, user.Image)
+	return err
+}
+
+// UpdateUser saves the user's registration details and grants any roles in
+// user.Roles. Roles the user already has are left alone.
+func (store *mysqlStore) UpdateUser(ctx context.Context, user *User) error {
+	tx, err := store.db.WithContext(ctx).Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		update users set first_name = ?, last_name = ?, gender = ?, birthdate = ?, phone = ?
+		where email = ?
+	`, user.FirstName, user.LastName, user.Gender, user.Birthdate, user.Phone, user.Email)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.QueryRow(`
+		select id from users where email = ?
+	`, user.Email).Scan(&user.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, role := range user.Roles {
+		_, err = tx.Exec(`
+			insert into user_roles(user_id, role_id) values(?,?)
+		`, user.ID, role)
+		if err != nil && !isDuplicateKey(err) {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (store *mysqlStore) Roles(ctx context.Context) ([]string, error) {
+	rows, err := store.db.WithContext(ctx).Query(`
+		select id from roles
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, id)
+	}
+	return roles, rows.Err()
+}
+
+func (store *mysqlStore) UpdateScoring(ctx context.Context, gameID string, rules *ScoringRules) error {
+	return store.updateGame(ctx, gameID, "scoring", rules.String())
+}
+
+func (store *mysqlStore) UpdateAnswering(ctx context.Context, gameID string, rules *AnswerRules) error {
+	return store.updateGame(ctx, gameID, "answering", rules.String())
+}
+
+// updateGame sets one column of the game, returning sql.ErrNoRows when there
+// is no such game.
+func (store *mysqlStore) updateGame(ctx context.Context, gameID, column, value string) error {
+	conn := store.db.WithContext(ctx)
+
+	result, err := conn.Exec(`
+		update pbe.games set `+column+` = ? where id = ?
+	`, value, gameID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	// An update that changes nothing affects no rows either.
+	var count int
+	err = conn.QueryRow(`
+		select count(*) from pbe.games where id = ?
+	`, gameID).Scan(&count)
+	if err == nil && count == 0 {
+		err = sql.ErrNoRows
+	}
+	return err
+}
+
+func (store *mysqlStore) TeamMembers(ctx context.Context, gameID, teamID string) ([]*TeamMember, error) {
+	conn := store.db.WithContext(ctx)
+
+	err := requireGameTeam(conn, gameID, teamID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(`
+		select u.id, u.first_name, u.last_name, coalesce(u.email, ''), tm.role
+		from `+teamMembers+` tm
+		inner join users u on u.id = tm.user_id
+		where tm.team_id = ?
+		order by tm.role, u.last_name, u.first_name
+	`, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*TeamMember{}
+	for rows.Next() {
+		member := &TeamMember{}
+		err = rows.Scan(&member.UserID, &member.FirstName, &member.LastName, &member.Email, &member.Role)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// AddTeamMember looks the user up by member.UserID or member.Email, fills in
+// the rest of member and adds them to the team, or changes their role if they
+// are already on it.
+func (store *mysqlStore) AddTeamMember(ctx context.Context, gameID, teamID string, member *TeamMember) error {
+	conn := store.db.WithContext(ctx)
+
+	err := requireGameTeam(conn, gameID, teamID)
+	if err != nil {
+		return err
+	}
+
+	err = conn.QueryRow(`
+		select id, first_name, last_name, coalesce(email, '')
+		from users
+		where id = ? or (? <> '' and email = ?)
+	`, member.UserID, member.Email, member.Email).Scan(&member.UserID, &member.FirstName, &member.LastName, &member.Email)
+	if err == sql.ErrNoRows {
+		return &NotFoundError{"User", member.lookup()}
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Exec(`
+		insert into pbe.team_members(team_id, user_id, role)
+		values(?,?,?)
+		on duplicate key update role = values(role)
+	`, teamID, member.UserID, member.Role)
+	return err
+}
+
+func (store *mysqlStore) DeleteTeamMember(ctx context.Context, gameID, teamID, userID string) error {
+	conn := store.db.WithContext(ctx)
+
+	err := requireGameTeam(conn, gameID, teamID)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Exec(`
+		delete from pbe.team_members where team_id = ? and user_id = ?
+	`, teamID, userID)
+	return err
+}
+
+func (store *mysqlStore) TeamMember(ctx context.Context, teamID, email string) (bool, error) {
+	return isTeamMember(store.db.WithContext(ctx), teamID, email)
+}
+
+func (store *mysqlStore) UserTeam(ctx context.Context, gameID, email string) (string, error) {
+	return userTeam(store.db.WithContext(ctx), gameID, email)
+}
+
+// StartGame selects the game's questions, makes the first one current and
+// starts the game.
+func (store *mysqlStore) StartGame(ctx context.Context, gameID, userID string) ([]*Question, error) {
+	conn := store.db.WithContext(ctx)
+
+	game, err := store.Game(ctx, gameID)
+	if err != nil {
+		return nil, err
+	}
+	if game.Status != GameOpen {
+		return nil, &StatusError{GameID: gameID, Status: game.Status, Expected: []string{GameOpen}}
+	}
+
+	questions, err := selectGameQuestions(conn, game)
+	if err != nil {
+		return nil, err
+	}
+	if len(questions) == 0 {
+		return nil, errNoQuestions
+	}
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = requireGameStatus(tx, gameID, GameOpen)
+	if err == nil {
+		_, err = transitionGame(tx, gameID, GameStarted, userID)
+	}
+	if err == nil {
+		err = insertGameQuestions(tx, gameID, questions)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return questions, tx.Commit()
+}
+
+// TransitionGame moves the game to another status. When from statuses are
+// given, the game must be in one of them.
+func (store *mysqlStore) TransitionGame(ctx context.Context, gameID, to, userID string, from ...string) error {
+	tx, err := store.db.WithContext(ctx).Begin()
+	if err != nil {
+		return err
+	}
+
+	if len(from) > 0 {
+		_, err = requireGameStatus(tx, gameID, from...)
+	}
+	if err == nil {
+		_, err = transitionGame(tx, gameID, to, userID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *mysqlStore) NextQuestion(ctx context.Context, gameID, userID string) error {
+	tx, err := store.db.WithContext(ctx).Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = requireGameStatus(tx, gameID, GameStarted)
+	if err == nil {
+		err = nextQuestion(tx, gameID, userID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *mysqlStore) PreviousQuestion(ctx context.Context, gameID string) error {
+	tx, err := store.db.WithContext(ctx).Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = requireGameStatus(tx, gameID, GameStarted)
+	if err == nil {
+		err = previousQuestion(tx, gameID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *mysqlStore) CurrentQuestion(ctx context.Context, gameID string) (*Question, error) {
+	return getCurrentQuestion(store.db.WithContext(ctx), gameID)
+}
+
+func (store *mysqlStore) GameTimer(ctx context.Context, gameID string) (*GameTimer, error) {
+	return getGameTimer(store.db.WithContext(ctx), gameID)
+}
+
+func (store *mysqlStore) TimedGames(ctx context.Context) ([]string, error) {
+	return getTimedGames(store.db.WithContext(ctx))
+}
+
+func (store *mysqlStore) AdvanceExpiredQuestion(ctx context.Context, gameID, gameQuestionID string) (bool, error) {
+	return advanceExpiredQuestion(store.db.WithContext(ctx), gameID, gameQuestionID)
+}
+
+func (store *mysqlStore) AddTeamAnswer(ctx context.Context, gameID, teamID, answerID string) (string, error) {
+	tx, err := store.db.WithContext(ctx).Begin()
+	if err != nil {
+		return "", err
+	}
+
+	id, err := addTeamAnswer(tx, gameID, teamID, answerID)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	return id, tx.Commit()
+}
+
+func (store *mysqlStore) DeleteTeamAnswer(ctx context.Context, gameID, teamID, answerID string) error {
+	tx, err := store.db.WithContext(ctx).Begin()
+	if err != nil {
+		return err
+	}
+
+	err = deleteTeamAnswer(tx, gameID, teamID, answerID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *mysqlStore) LockTeamAnswers(ctx context.Context, gameID, teamID, userID string) error {
+	tx, err := store.db.WithContext(ctx).Begin()
+	if err != nil {
+		return err
+	}
+
+	err = lockTeamAnswers(tx, gameID, teamID, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *mysqlStore) Scoreboard(ctx context.Context, gameID string, players bool) (*Scoreboard, error) {
+	return buildScoreboard(store.db.WithContext(ctx), gameID, players)
+}
+
+func (store *mysqlStore) ScoreTeams(ctx context.Context, game *Game, teams []*Team) error {
+	return scoreTeams(store.db.WithContext(ctx), game, teams)
+}
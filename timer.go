@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// GameTimer struct
+type GameTimer struct {
+	Type           string `json:"type"`
+	GameID         string `json:"gameId"`
+	GameQuestionID string `json:"gameQuestionId"`
+	Status         string `json:"status"`
+	Seconds        int    `json:"seconds"`
+	Remaining      int    `json:"remaining"`
+	Expired        bool   `json:"expired"`
+	AutoAdvance    bool   `json:"autoAdvance"`
+	RejectLate     bool   `json:"rejectLate"`
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// getGameTimer returns the countdown for the game's current question. Games
+// without a time limit never expire. Elapsed time is measured by the database
+// so every server instance agrees on the deadline, and it stops counting while
+// the game is paused.
+func getGameTimer(q queryRower, gameID string) (*GameTimer, error) {
+	timer := &GameTimer{
+		Type:   "timer",
+		GameID: gameID,
+	}
+	var elapsed int
+	err := q.QueryRow(`
+		select coalesce(g.question, ''), coalesce(g.status, 'OPEN'), g.seconds, g.auto_advance, g.reject_late,
+			coalesce(timestampdiff(second, gq.started, coalesce(g.paused, NOW())), 0)
+		from pbe.games g
+		left join pbe.game_questions gq on gq.id = g.question
+		where g.id = ?
+	`, gameID).Scan(&timer.GameQuestionID, &timer.Status, &timer.Seconds, &timer.AutoAdvance, &timer.RejectLate, &elapsed)
+	if err != nil {
+		return nil, err
+	}
+
+	if timer.Seconds <= 0 || len(timer.GameQuestionID) == 0 {
+		return timer, nil
+	}
+	timer.Remaining = timer.Seconds - elapsed
+	if timer.Remaining <= 0 {
+		timer.Remaining = 0
+		timer.Expired = true
+	}
+	return timer, nil
+}
+
+// pauseGameTimer freezes the current question's countdown when a game is
+// paused and pushes its start time forward by the paused time on resume.
+func pauseGameTimer(tx *sql.Tx, gameID, from, to string) error {
+	if to == GamePaused {
+		_, err := tx.Exec(`
+			update pbe.games set paused = NOW() where id = ?
+		`, gameID)
+		return err
+	}
+	if from != GamePaused {
+		return nil
+	}
+
+	_, err := tx.Exec(`
+		update pbe.game_questions gq
+		inner join pbe.games g on g.question = gq.id
+		set gq.started = date_add(gq.started, interval timestampdiff(second, g.paused, NOW()) second)
+		where g.id = ? and g.paused is not null
+	`, gameID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		update pbe.games set paused = null where id = ?
+	`, gameID)
+	return err
+}
+
+func (s *Server) getGameTimerController(c echo.Context) error {
+	gameID := c.Param("gameID")
+
+	timer, err := s.store.GameTimer(c.Request().Context(), gameID)
+	if err != nil {
+		log.Error("Could not get game timer: ", gameID, " : ", err)
+		return c.JSON(gameErrorStatus(err), "Could not get game timer: "+gameID+" : "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, timer)
+}
+
+// runGameTimers publishes the countdown of every running timed game once per
+// interval, and moves games with autoAdvance on to the next question when time
+// runs out.
+func (s *Server) runGameTimers(interval time.Duration) {
+	ctx := context.Background()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		gameIDs, err := s.store.TimedGames(ctx)
+		if err != nil {
+			log.Error("Could not get timed games: ", err)
+			continue
+		}
+
+		for _, gameID := range gameIDs {
+			timer, err := s.store.GameTimer(ctx, gameID)
+			if err != nil {
+				log.Error("Could not get game timer: ", gameID, " : ", err)
+				continue
+			}
+
+			// Every instance runs the timers, so ticks are not shared.
+			s.bus.Publish(&Event{Type: MsgTimerTick, GameID: gameID, Local: true, Payload: timer})
+
+			if timer.Expired && timer.AutoAdvance {
+				advanced, err := s.store.AdvanceExpiredQuestion(ctx, gameID, timer.GameQuestionID)
+				if err != nil {
+					log.Error("Could not advance game: ", gameID, " : ", err)
+					continue
+				}
+				if advanced {
+					s.publishGame(gameID)
+				}
+			}
+		}
+	}
+}
+
+func getTimedGames(conn *Conn) ([]string, error) {
+	rows, err := conn.Query(`
+		select id
+		from pbe.games
+		where status = 'STARTED' and seconds > 0 and question is not null
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	gameIDs := []string{}
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		gameIDs = append(gameIDs, id)
+	}
+	return gameIDs, rows.Err()
+}
+
+// advanceExpiredQuestion moves to the next question only if the expired one
+// is still current, so a moderator pressing next at the same time does not
+// skip a question. It reports whether it moved on.
+func advanceExpiredQuestion(conn *Conn, gameID, gameQuestionID string) (bool, error) {
+	tx, err := conn.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	_, err = requireGameStatus(tx, gameID, GameStarted)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	timer, err := getGameTimer(tx, gameID)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if timer.GameQuestionID != gameQuestionID || !timer.Expired {
+		tx.Rollback()
+		return false, nil
+	}
+
+	err = nextQuestion(tx, gameID, "")
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
@@ -0,0 +1,800 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+)
+
+// Tournament formats
+const (
+	FormatRoundRobin = "ROUND_ROBIN"
+	FormatBracket    = "BRACKET"
+)
+
+// MatchBye is the status of a match where a club had no opponent.
+const MatchBye = "BYE"
+
+// Tournament struct
+type Tournament struct {
+	ID      string             `json:"id"`
+	Name    string             `json:"name"`
+	Format  string             `json:"format"`
+	Status  string             `json:"status"`
+	Created time.Time          `json:"created"`
+	Clubs   []*TournamentClub  `json:"clubs"`
+	Rounds  []*TournamentRound `json:"rounds"`
+	ClubIDs []string           `json:"clubIds"`
+}
+
+// TournamentClub struct
+type TournamentClub struct {
+	ClubID string `json:"clubId"`
+	Name   string `json:"name"`
+	Seed   int    `json:"seed"`
+}
+
+// TournamentRound struct
+type TournamentRound struct {
+	ID      string             `json:"id"`
+	Number  int                `json:"number"`
+	Created time.Time          `json:"created"`
+	Matches []*TournamentMatch `json:"matches"`
+}
+
+// TournamentMatch struct
+type TournamentMatch struct {
+	ID       string            `json:"id"`
+	Position int               `json:"position"`
+	GameID   string            `json:"gameId"`
+	GameName string            `json:"gameName"`
+	Status   string            `json:"status"`
+	ClubIDs  []string          `json:"clubIds"`
+	WinnerID string            `json:"winnerId"`
+	Draw     bool              `json:"draw"`
+	Results  []*ScoreboardTeam `json:"results"`
+}
+
+// TournamentStanding struct
+type TournamentStanding struct {
+	ClubID     string  `json:"clubId"`
+	Name       string  `json:"name"`
+	Seed       int     `json:"seed"`
+	Played     int     `json:"played"`
+	Wins       int     `json:"wins"`
+	Losses     int     `json:"losses"`
+	Draws      int     `json:"draws"`
+	Points     float64 `json:"points"`
+	Rank       int     `json:"rank"`
+	Eliminated bool    `json:"eliminated"`
+}
+
+func (s *Server) getTournamentsController(c echo.Context) error {
+	conn := s.db.WithContext(c.Request().Context())
+
+	rows, err := conn.Query(`
+		select id, name, format, status, created
+		from pbe.tournaments
+		order by created desc
+	`)
+	if err != nil {
+		log.Error("Could not get tournaments: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get tournaments: "+err.Error())
+	}
+	defer rows.Close()
+
+	tournaments := []*Tournament{}
+	for rows.Next() {
+		var created string
+		tournament := &Tournament{}
+		err = rows.Scan(&tournament.ID, &tournament.Name, &tournament.Format, &tournament.Status, &created)
+		if err != nil {
+			log.Error("Could not get tournament: ", err)
+			return c.JSON(http.StatusInternalServerError, "Could not get tournament: "+err.Error())
+		}
+		tournament.Created, _ = time.Parse("2006-01-02 15:04:05", created)
+		tournaments = append(tournaments, tournament)
+	}
+
+	return c.JSON(http.StatusOK, tournaments)
+}
+
+// addTournamentController creates a tournament. Clubs given in clubIds are
+// enrolled seeded in the order they are listed.
+func (s *Server) addTournamentController(c echo.Context) error {
+	tournament := &Tournament{}
+	err := c.Bind(&tournament)
+	if err != nil {
+		log.Error("Could not parse tournament: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse tournament: "+err.Error())
+	}
+	if len(strings.TrimSpace(tournament.Name)) == 0 {
+		return c.JSON(http.StatusBadRequest, "name is required")
+	}
+	if len(tournament.Format) == 0 {
+		tournament.Format = FormatRoundRobin
+	}
+	if tournament.Format != FormatRoundRobin && tournament.Format != FormatBracket {
+		return c.JSON(http.StatusBadRequest, "format must be "+FormatRoundRobin+" or "+FormatBracket)
+	}
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	tx, err := conn.Begin()
+	if err != nil {
+		log.Error("Could not create database transaction: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not create database transaction: "+err.Error())
+	}
+
+	tournament.ID, _ = UUID()
+	_, err = tx.Exec(`
+		insert into pbe.tournaments(id, name, format, status, created)
+		values(?,?,?,'OPEN',NOW())
+	`, tournament.ID, tournament.Name, tournament.Format)
+	if err != nil {
+		log.Error("Could not create tournament: ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not create tournament: "+err.Error())
+	}
+
+	for i, clubID := range tournament.ClubIDs {
+		_, err = tx.Exec(`
+			insert into pbe.tournament_clubs(tournament_id, club_id, seed)
+			values(?,?,?)
+		`, tournament.ID, clubID, i+1)
+		if err != nil {
+			log.Error("Could not enroll club: ", clubID, " : ", err)
+			tx.Rollback()
+			return c.JSON(http.StatusInternalServerError, "Could not enroll club: "+clubID+" : "+err.Error())
+		}
+	}
+
+	tx.Commit()
+
+	tournament, err = getTournament(conn, tournament.ID)
+	if err != nil {
+		log.Error("Could not get tournament: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get tournament: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, tournament)
+}
+
+func (s *Server) getTournamentController(c echo.Context) error {
+	tournamentID := c.Param("tournamentID")
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	tournament, err := getTournament(conn, tournamentID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, "Tournament not found: "+tournamentID)
+	}
+	if err != nil {
+		log.Error("Could not get tournament: ", tournamentID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get tournament: "+tournamentID+" : "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, tournament)
+}
+
+// deleteTournamentController removes the tournament and its rounds. The games
+// played in it are kept.
+func (s *Server) deleteTournamentController(c echo.Context) error {
+	tournamentID := c.Param("tournamentID")
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	_, err := conn.Exec(`
+		delete from pbe.tournaments where id = ?
+	`, tournamentID)
+	if err != nil {
+		log.Error("Could not delete tournament: ", tournamentID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not delete tournament: "+tournamentID+" : "+err.Error())
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// addTournamentClubController enrolls a club before the first round. Without
+// the seed query parameter the club is seeded last.
+func (s *Server) addTournamentClubController(c echo.Context) error {
+	tournamentID := c.Param("tournamentID")
+	clubID := c.Param("clubID")
+	seed, _ := strconv.Atoi(c.QueryParam("seed"))
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	tx, err := conn.Begin()
+	if err != nil {
+		log.Error("Could not create database transaction: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not create database transaction: "+err.Error())
+	}
+
+	var status string
+	err = tx.QueryRow(`
+		select status from pbe.tournaments where id = ? for update
+	`, tournamentID).Scan(&status)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return c.JSON(http.StatusNotFound, "Tournament not found: "+tournamentID)
+	}
+	if err != nil {
+		log.Error("Could not get tournament: ", tournamentID, " : ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not get tournament: "+tournamentID+" : "+err.Error())
+	}
+	if status != GameOpen {
+		tx.Rollback()
+		return c.JSON(http.StatusConflict, "Clubs can only be added before the first round")
+	}
+
+	err = requireClub(tx, clubID)
+	if _, ok := err.(*ClubNotFoundError); ok {
+		tx.Rollback()
+		return c.JSON(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		log.Error("Could not get club: ", clubID, " : ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not get club: "+clubID+" : "+err.Error())
+	}
+
+	if seed <= 0 {
+		err = tx.QueryRow(`
+			select coalesce(max(seed), 0) + 1 from pbe.tournament_clubs where tournament_id = ?
+		`, tournamentID).Scan(&seed)
+		if err != nil {
+			log.Error("Could not get seed: ", err)
+			tx.Rollback()
+			return c.JSON(http.StatusInternalServerError, "Could not get seed: "+err.Error())
+		}
+	}
+
+	_, err = tx.Exec(`
+		insert into pbe.tournament_clubs(tournament_id, club_id, seed)
+		values(?,?,?)
+		on duplicate key update seed = values(seed)
+	`, tournamentID, clubID, seed)
+	if err != nil {
+		log.Error("Could not enroll club: ", clubID, " : ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not enroll club: "+clubID+" : "+err.Error())
+	}
+
+	tx.Commit()
+
+	tournament, err := getTournament(conn, tournamentID)
+	if err != nil {
+		log.Error("Could not get tournament: ", tournamentID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get tournament: "+tournamentID+" : "+err.Error())
+	}
+	return c.JSON(http.StatusOK, tournament)
+}
+
+// addTournamentRoundController starts the next round once every game of the
+// previous one is over and returns it. The body holds the game settings used
+// for each match. When no round is left the tournament is finished instead and
+// the finished tournament is returned.
+func (s *Server) addTournamentRoundController(c echo.Context) error {
+	tournamentID := c.Param("tournamentID")
+	settings := &Game{}
+	err := c.Bind(&settings)
+	if err != nil {
+		log.Error("Could not parse round: ", err)
+		return c.JSON(http.StatusBadRequest, "Could not parse round: "+err.Error())
+	}
+	if settings.Answering != nil {
+		err = settings.Answering.validate()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	tx, err := conn.Begin()
+	if err != nil {
+		log.Error("Could not create database transaction: ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not create database transaction: "+err.Error())
+	}
+
+	// Locking the tournament makes concurrent requests for the next round
+	// wait here, so each sees the rounds the others created.
+	var status string
+	err = tx.QueryRow(`
+		select status from pbe.tournaments where id = ? for update
+	`, tournamentID).Scan(&status)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return c.JSON(http.StatusNotFound, "Tournament not found: "+tournamentID)
+	}
+	if err != nil {
+		log.Error("Could not get tournament: ", tournamentID, " : ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not get tournament: "+tournamentID+" : "+err.Error())
+	}
+	if status == GameFinished {
+		tx.Rollback()
+		return c.JSON(http.StatusConflict, "Tournament is finished")
+	}
+
+	tournament, err := getTournament(conn, tournamentID)
+	if err != nil {
+		log.Error("Could not get tournament: ", tournamentID, " : ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not get tournament: "+tournamentID+" : "+err.Error())
+	}
+	if len(tournament.Clubs) < 2 {
+		tx.Rollback()
+		return c.JSON(http.StatusConflict, "A tournament needs at least two clubs")
+	}
+
+	var previous *TournamentRound
+	if len(tournament.Rounds) > 0 {
+		previous = tournament.Rounds[len(tournament.Rounds)-1]
+		over, err := tournamentRoundOver(tx, previous.ID)
+		if err != nil {
+			log.Error("Could not get round: ", previous.ID, " : ", err)
+			tx.Rollback()
+			return c.JSON(http.StatusInternalServerError, "Could not get round: "+previous.ID+" : "+err.Error())
+		}
+		if !over {
+			tx.Rollback()
+			return c.JSON(http.StatusConflict, "Round "+strconv.Itoa(previous.Number)+" is not over yet")
+		}
+	}
+
+	pairs := nextTournamentPairs(tournament, previous)
+
+	if pairs == nil {
+		_, err = tx.Exec(`
+			update pbe.tournaments set status = 'FINISHED' where id = ?
+		`, tournamentID)
+		if err != nil {
+			log.Error("Could not finish tournament: ", tournamentID, " : ", err)
+			tx.Rollback()
+			return c.JSON(http.StatusInternalServerError, "Could not finish tournament: "+tournamentID+" : "+err.Error())
+		}
+		tx.Commit()
+
+		tournament.Status = GameFinished
+		return c.JSON(http.StatusOK, tournament)
+	}
+
+	round := &TournamentRound{Number: len(tournament.Rounds) + 1, Created: time.Now()}
+	round.ID, _ = UUID()
+	_, err = tx.Exec(`
+		insert into pbe.tournament_rounds(id, tournament_id, number, created)
+		values(?,?,?,NOW())
+	`, round.ID, tournamentID, round.Number)
+	if err != nil {
+		log.Error("Could not create round: ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusConflict, "Could not create round: "+err.Error())
+	}
+
+	_, err = tx.Exec(`
+		update pbe.tournaments set status = 'STARTED' where id = ?
+	`, tournamentID)
+	if err != nil {
+		log.Error("Could not start tournament: ", tournamentID, " : ", err)
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, "Could not start tournament: "+tournamentID+" : "+err.Error())
+	}
+
+	for i, pair := range pairs {
+		match := &TournamentMatch{Position: i}
+		match.ID, _ = UUID()
+
+		var byeClubID interface{}
+		if len(pair) == 1 {
+			byeClubID = pair[0]
+		} else {
+			game := tournamentGame(settings, tournament, round.Number, i, pair)
+			err = insertGame(tx, game, currentUser(c))
+			if err != nil {
+				log.Error(err)
+				tx.Rollback()
+				return c.JSON(http.StatusInternalServerError, err.Error())
+			}
+			match.GameID = game.ID
+		}
+
+		_, err = tx.Exec(`
+			insert into pbe.tournament_matches(id, round_id, position, game_id, bye_club_id)
+			values(?,?,?,nullif(?, ''),?)
+		`, match.ID, round.ID, match.Position, match.GameID, byeClubID)
+		if err != nil {
+			log.Error("Could not create match: ", err)
+			tx.Rollback()
+			return c.JSON(http.StatusInternalServerError, "Could not create match: "+err.Error())
+		}
+	}
+
+	tx.Commit()
+
+	tournament, err = getTournament(conn, tournamentID)
+	if err != nil {
+		log.Error("Could not get tournament: ", tournamentID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get tournament: "+tournamentID+" : "+err.Error())
+	}
+	return c.JSON(http.StatusOK, tournament.Rounds[len(tournament.Rounds)-1])
+}
+
+// tournamentRoundOver locks the games of the round's matches and reports
+// whether every one of them is finished or cancelled.
+func tournamentRoundOver(tx *sql.Tx, roundID string) (bool, error) {
+	var playing int
+	err := tx.QueryRow(`
+		select count(*)
+		from pbe.tournament_matches m
+		inner join pbe.games g on g.id = m.game_id
+		where m.round_id = ?
+		and coalesce(g.status, 'OPEN') not in ('FINISHED', 'CANCELLED')
+		for update
+	`, roundID).Scan(&playing)
+	return playing == 0, err
+}
+
+// tournamentGame builds the game for one match from the round's settings.
+func tournamentGame(settings *Game, tournament *Tournament, round, position int, clubIDs []string) *Game {
+	game := &Game{
+		Name:        settings.Name,
+		Seconds:     settings.Seconds,
+		Questions:   settings.Questions,
+		AutoAdvance: settings.AutoAdvance,
+		RejectLate:  settings.RejectLate,
+		Scoring:     settings.Scoring,
+		Answering:   settings.Answering,
+		ClubIDs:     clubIDs,
+	}
+	if len(game.Name) == 0 {
+		game.Name = fmt.Sprintf("%s R%d M%d", tournament.Name, round, position+1)
+	}
+	for _, chapter := range settings.Chapters {
+		game.Chapters = append(game.Chapters, &GameChapter{Book: chapter.Book, Chapter: chapter.Chapter})
+	}
+	return game
+}
+
+// nextTournamentPairs returns the clubs playing each match of the next round,
+// a single club being a bye, or nil when the tournament is over.
+func nextTournamentPairs(tournament *Tournament, previous *TournamentRound) [][]string {
+	clubIDs := []string{}
+	for _, club := range tournament.Clubs {
+		clubIDs = append(clubIDs, club.ClubID)
+	}
+
+	if tournament.Format == FormatRoundRobin {
+		return roundRobinPairs(clubIDs, len(tournament.Rounds))
+	}
+
+	if previous == nil {
+		return bracketPairs(clubIDs)
+	}
+	winners := []string{}
+	for _, match := range previous.Matches {
+		if len(match.WinnerID) > 0 {
+			winners = append(winners, match.WinnerID)
+		}
+	}
+	if len(winners) < 2 {
+		return nil
+	}
+	pairs := [][]string{}
+	for i := 0; i < len(winners); i += 2 {
+		end := i + 2
+		if end > len(winners) {
+			end = len(winners)
+		}
+		pairs = append(pairs, winners[i:end])
+	}
+	return pairs
+}
+
+// roundRobinPairs pairs the clubs for the given zero based round using the
+// circle method, so every club meets every other club once. With an odd
+// number of clubs one club sits out each round.
+func roundRobinPairs(clubIDs []string, round int) [][]string {
+	clubs := append([]string{}, clubIDs...)
+	if len(clubs)%2 == 1 {
+		clubs = append(clubs, "")
+	}
+	n := len(clubs)
+	if round >= n-1 {
+		return nil
+	}
+
+	rotated := []string{clubs[0]}
+	for i := 0; i < n-1; i++ {
+		rotated = append(rotated, clubs[1+(i+round)%(n-1)])
+	}
+
+	pairs := [][]string{}
+	for i := 0; i < n/2; i++ {
+		pair := []string{}
+		for _, clubID := range []string{rotated[i], rotated[n-1-i]} {
+			if len(clubID) > 0 {
+				pair = append(pair, clubID)
+			}
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs
+}
+
+// bracketPairs seeds the first round of a single elimination bracket. The
+// bracket is filled up to a power of two with byes for the top seeds, and the
+// seeds are placed so the top two can only meet in the final.
+func bracketPairs(clubIDs []string) [][]string {
+	size := 1
+	for size < len(clubIDs) {
+		size *= 2
+	}
+	order := []int{1}
+	for len(order) < size {
+		next := []int{}
+		for _, seed := range order {
+			next = append(next, seed, 2*len(order)+1-seed)
+		}
+		order = next
+	}
+
+	pairs := [][]string{}
+	for i := 0; i < size; i += 2 {
+		pair := []string{}
+		for _, seed := range order[i : i+2] {
+			if seed <= len(clubIDs) {
+				pair = append(pair, clubIDs[seed-1])
+			}
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs
+}
+
+// getTournament loads the tournament with its clubs in seed order and every
+// round's matches and results.
+func getTournament(conn *Conn, tournamentID string) (*Tournament, error) {
+	var created string
+	tournament := &Tournament{ID: tournamentID, ClubIDs: []string{}}
+	err := conn.QueryRow(`
+		select name, format, status, created
+		from pbe.tournaments
+		where id = ?
+	`, tournamentID).Scan(&tournament.Name, &tournament.Format, &tournament.Status, &created)
+	if err != nil {
+		return nil, err
+	}
+	tournament.Created, _ = time.Parse("2006-01-02 15:04:05", created)
+
+	rows, err := conn.Query(`
+		select tc.club_id, c.name, tc.seed
+		from pbe.tournament_clubs tc
+		inner join pbe.clubs c on c.id = tc.club_id
+		where tc.tournament_id = ?
+		order by tc.seed, c.name
+	`, tournamentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tournament.Clubs = []*TournamentClub{}
+	seeds := map[string]int{}
+	for rows.Next() {
+		club := &TournamentClub{}
+		err = rows.Scan(&club.ClubID, &club.Name, &club.Seed)
+		if err != nil {
+			return nil, err
+		}
+		tournament.Clubs = append(tournament.Clubs, club)
+		tournament.ClubIDs = append(tournament.ClubIDs, club.ClubID)
+		seeds[club.ClubID] = club.Seed
+	}
+	rows.Close()
+
+	rows, err = conn.Query(`
+		select r.id, r.number, r.created, m.id, m.position,
+			coalesce(m.game_id, ''), coalesce(g.name, ''), coalesce(g.status, 'OPEN'), coalesce(m.bye_club_id, '')
+		from pbe.tournament_rounds r
+		inner join pbe.tournament_matches m on m.round_id = r.id
+		left join pbe.games g on g.id = m.game_id
+		where r.tournament_id = ?
+		order by r.number, m.position
+	`, tournamentID)
+	if err != nil {
+		return nil, err
+	}
+
+	tournament.Rounds = []*TournamentRound{}
+	var round *TournamentRound
+	for rows.Next() {
+		var roundID, roundCreated, byeClubID string
+		var number int
+		match := &TournamentMatch{ClubIDs: []string{}, Results: []*ScoreboardTeam{}}
+		err = rows.Scan(&roundID, &number, &roundCreated, &match.ID, &match.Position,
+			&match.GameID, &match.GameName, &match.Status, &byeClubID)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if round == nil || round.ID != roundID {
+			round = &TournamentRound{ID: roundID, Number: number, Matches: []*TournamentMatch{}}
+			round.Created, _ = time.Parse("2006-01-02 15:04:05", roundCreated)
+			tournament.Rounds = append(tournament.Rounds, round)
+		}
+		if len(byeClubID) > 0 {
+			match.Status = MatchBye
+			match.ClubIDs = append(match.ClubIDs, byeClubID)
+			match.WinnerID = byeClubID
+		}
+		round.Matches = append(round.Matches, match)
+	}
+	rows.Close()
+
+	for _, round := range tournament.Rounds {
+		for _, match := range round.Matches {
+			if len(match.GameID) == 0 {
+				continue
+			}
+			err = scoreTournamentMatch(conn, tournament.Format, seeds, match)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return tournament, nil
+}
+
+// scoreTournamentMatch fills in the match's clubs and, once its game is over,
+// the results and winner. A tie for first is a draw in a round robin; in a
+// bracket, and when the game was cancelled, the better seed goes through.
+func scoreTournamentMatch(conn *Conn, format string, seeds map[string]int, match *TournamentMatch) error {
+	rows, err := conn.Query(`
+		select club_id from pbe.teams where game_id = ? and club_id is not null order by name
+	`, match.GameID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var clubID string
+		err = rows.Scan(&clubID)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		match.ClubIDs = append(match.ClubIDs, clubID)
+	}
+	rows.Close()
+
+	leaders := []string{}
+	switch match.Status {
+	case GameFinished:
+		scoreboard, err := getScoreboard(conn, match.GameID)
+		if err != nil {
+			return err
+		}
+		match.Results = scoreboard.Teams
+		for _, team := range scoreboard.Teams {
+			if team.Rank == 1 && len(team.ClubID) > 0 {
+				leaders = append(leaders, team.ClubID)
+			}
+		}
+		if len(leaders) > 1 && format == FormatRoundRobin {
+			match.Draw = true
+			return nil
+		}
+	case GameCancelled:
+		if format == FormatRoundRobin {
+			return nil
+		}
+		leaders = match.ClubIDs
+	default:
+		return nil
+	}
+
+	for _, clubID := range leaders {
+		if len(match.WinnerID) == 0 || seeds[clubID] < seeds[match.WinnerID] {
+			match.WinnerID = clubID
+		}
+	}
+	return nil
+}
+
+// getTournamentStandingsController ranks the tournament's clubs by wins, then
+// draws, then total points across every finished match.
+func (s *Server) getTournamentStandingsController(c echo.Context) error {
+	tournamentID := c.Param("tournamentID")
+
+	conn := s.db.WithContext(c.Request().Context())
+
+	tournament, err := getTournament(conn, tournamentID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, "Tournament not found: "+tournamentID)
+	}
+	if err != nil {
+		log.Error("Could not get tournament: ", tournamentID, " : ", err)
+		return c.JSON(http.StatusInternalServerError, "Could not get tournament: "+tournamentID+" : "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, tournamentStandings(tournament))
+}
+
+func tournamentStandings(tournament *Tournament) []*TournamentStanding {
+	standings := []*TournamentStanding{}
+	byClub := map[string]*TournamentStanding{}
+	for _, club := range tournament.Clubs {
+		standing := &TournamentStanding{ClubID: club.ClubID, Name: club.Name, Seed: club.Seed}
+		standings = append(standings, standing)
+		byClub[club.ClubID] = standing
+	}
+
+	bracket := tournament.Format == FormatBracket
+	for _, round := range tournament.Rounds {
+		for _, match := range round.Matches {
+			if match.Status == GameCancelled && bracket {
+				for _, clubID := range match.ClubIDs {
+					if standing, ok := byClub[clubID]; ok && clubID != match.WinnerID {
+						standing.Eliminated = true
+					}
+				}
+			}
+			if match.Status != GameFinished {
+				continue
+			}
+			for _, team := range match.Results {
+				standing, ok := byClub[team.ClubID]
+				if !ok {
+					continue
+				}
+				standing.Played++
+				standing.Points += team.Points
+				switch {
+				case match.Draw:
+					standing.Draws++
+				case team.ClubID == match.WinnerID:
+					standing.Wins++
+				default:
+					standing.Losses++
+					if bracket {
+						standing.Eliminated = true
+					}
+				}
+			}
+		}
+	}
+
+	for _, standing := range standings {
+		standing.Points = round2(standing.Points)
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		if a.Draws != b.Draws {
+			return a.Draws > b.Draws
+		}
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		return a.Seed < b.Seed
+	})
+	for i, standing := range standings {
+		standing.Rank = i + 1
+		if i > 0 {
+			prev := standings[i-1]
+			if standing.Wins == prev.Wins && standing.Draws == prev.Draws && standing.Points == prev.Points {
+				standing.Rank = prev.Rank
+			}
+		}
+	}
+	return standings
+}
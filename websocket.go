@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
+	melody "gopkg.in/olahol/melody.v1"
+)
+
+// wsProtocolVersion is the version of the websocket protocol. It goes up when
+// a message changes in a way old clients cannot read.
+const wsProtocolVersion = 1
+
+// Websocket message types. Clients send subscribe; everything else comes
+// from the server as it happens.
+const (
+	// MsgSubscribe asks for the channel's current state: the games on
+	// /ws/pbe/teams, or the current question, scoreboard and timer on
+	// /ws/pbe/game. Only the sender gets the reply.
+	MsgSubscribe = "subscribe"
+	// MsgGames carries GamesPayload.
+	MsgGames = "games"
+	// MsgQuestionChanged carries QuestionChangedPayload.
+	MsgQuestionChanged = "question_changed"
+	// MsgAnswerSubmitted carries AnswerSubmittedPayload.
+	MsgAnswerSubmitted = "answer_submitted"
+	// MsgScoreboard carries a Scoreboard. Players' scoreboards leave out
+	// the question being asked until the game moves on.
+	MsgScoreboard = "scoreboard"
+	// MsgTimerTick carries a GameTimer.
+	MsgTimerTick = "timer_tick"
+	// MsgGameFinished carries GameFinishedPayload.
+	MsgGameFinished = "game_finished"
+	// MsgAppealFiled carries an Appeal. Only moderators get it.
+	MsgAppealFiled = "appeal_filed"
+	// MsgError carries ErrorPayload, in reply to a message the server
+	// could not accept.
+	MsgError = "error"
+)
+
+// Envelope is every websocket message in either direction. GameID names the
+// game the message is about and is required on /ws/pbe/game.
+type Envelope struct {
+	Version int             `json:"version"`
+	Type    string          `json:"type"`
+	GameID  string          `json:"gameId,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// EmptyPayload struct
+type EmptyPayload struct{}
+
+// GamesPayload struct
+type GamesPayload struct {
+	Games []*Game `json:"games"`
+}
+
+// QuestionChangedPayload struct. Before the game starts Question only holds a
+// waiting message, and once it is over it only has Finished set.
+type QuestionChangedPayload struct {
+	Question *Question `json:"question"`
+}
+
+// AnswerSubmittedPayload struct. Removed is set when the team took the answer
+// back.
+type AnswerSubmittedPayload struct {
+	TeamID   string `json:"teamId"`
+	AnswerID string `json:"answerId"`
+	Removed  bool   `json:"removed"`
+}
+
+// GameFinishedPayload struct
+type GameFinishedPayload struct {
+	Status     string      `json:"status"`
+	Scoreboard *Scoreboard `json:"scoreboard"`
+}
+
+// ErrorPayload struct
+type ErrorPayload struct {
+	Message string `json:"message"`
+}
+
+type wsPayload interface {
+	validate() error
+}
+
+// wsMessage describes a message type: who may send it, whether it must name a
+// game, and the payload it carries.
+type wsMessage struct {
+	fromClient bool
+	needsGame  bool
+	payload    func() wsPayload
+}
+
+var wsMessages = map[string]wsMessage{
+	MsgSubscribe:       {fromClient: true, payload: func() wsPayload { return &EmptyPayload{} }},
+	MsgGames:           {payload: func() wsPayload { return &GamesPayload{} }},
+	MsgQuestionChanged: {needsGame: true, payload: func() wsPayload { return &QuestionChangedPayload{} }},
+	MsgAnswerSubmitted: {needsGame: true, payload: func() wsPayload { return &AnswerSubmittedPayload{} }},
+	MsgScoreboard:      {needsGame: true, payload: func() wsPayload { return &Scoreboard{} }},
+	MsgTimerTick:       {needsGame: true, payload: func() wsPayload { return &GameTimer{} }},
+	MsgGameFinished:    {needsGame: true, payload: func() wsPayload { return &GameFinishedPayload{} }},
+	MsgAppealFiled:     {needsGame: true, payload: func() wsPayload { return &Appeal{} }},
+	MsgError:           {payload: func() wsPayload { return &ErrorPayload{} }},
+}
+
+func (payload *EmptyPayload) validate() error {
+	return nil
+}
+
+func (payload *GamesPayload) validate() error {
+	if payload.Games == nil {
+		return fmt.Errorf("games is required")
+	}
+	return nil
+}
+
+func (payload *QuestionChangedPayload) validate() error {
+	if payload.Question == nil {
+		return fmt.Errorf("question is required")
+	}
+	return nil
+}
+
+func (payload *AnswerSubmittedPayload) validate() error {
+	if len(payload.TeamID) == 0 || len(payload.AnswerID) == 0 {
+		return fmt.Errorf("teamId and answerId are required")
+	}
+	return nil
+}
+
+func (scoreboard *Scoreboard) validate() error {
+	if scoreboard.Teams == nil {
+		return fmt.Errorf("teams is required")
+	}
+	return nil
+}
+
+func (timer *GameTimer) validate() error {
+	if timer.Remaining < 0 || (timer.Seconds > 0 && timer.Remaining > timer.Seconds) {
+		return fmt.Errorf("remaining must be between 0 and %d", timer.Seconds)
+	}
+	return nil
+}
+
+func (payload *GameFinishedPayload) validate() error {
+	if payload.Status != GameFinished && payload.Status != GameCancelled {
+		return fmt.Errorf("status must be %s or %s", GameFinished, GameCancelled)
+	}
+	if payload.Scoreboard == nil {
+		return fmt.Errorf("scoreboard is required")
+	}
+	return payload.Scoreboard.validate()
+}
+
+func (appeal *Appeal) validate() error {
+	if len(appeal.ID) == 0 || len(appeal.TeamAnswerID) == 0 {
+		return fmt.Errorf("id and teamAnswerId are required")
+	}
+	return nil
+}
+
+func (payload *ErrorPayload) validate() error {
+	if len(payload.Message) == 0 {
+		return fmt.Errorf("message is required")
+	}
+	return nil
+}
+
+// newEnvelope checks a message the server is about to send and encodes it.
+func newEnvelope(msgType, gameID string, payload wsPayload) ([]byte, error) {
+	message, ok := wsMessages[msgType]
+	if !ok || message.fromClient {
+		return nil, fmt.Errorf("Unknown server message type: %s", msgType)
+	}
+	if message.needsGame && len(gameID) == 0 {
+		return nil, fmt.Errorf("%s needs a gameId", msgType)
+	}
+	err := payload.validate()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s payload: %v", msgType, err)
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&Envelope{
+		Version: wsProtocolVersion,
+		Type:    msgType,
+		GameID:  gameID,
+		Payload: raw,
+	})
+}
+
+// parseEnvelope decodes and checks a message from a client. gameID is the
+// game of the session it came in on, if any.
+func parseEnvelope(msg []byte, gameID string) (*Envelope, wsPayload, error) {
+	envelope := &Envelope{}
+	decoder := json.NewDecoder(bytes.NewReader(msg))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(envelope)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not parse message: %v", err)
+	}
+	if envelope.Version != wsProtocolVersion {
+		return nil, nil, fmt.Errorf("Unsupported protocol version %d, expected %d", envelope.Version, wsProtocolVersion)
+	}
+
+	message, ok := wsMessages[envelope.Type]
+	if !ok || !message.fromClient {
+		return nil, nil, fmt.Errorf("Unknown message type: %s", envelope.Type)
+	}
+	if envelope.GameID != gameID {
+		return nil, nil, fmt.Errorf("gameId must be %q on this channel", gameID)
+	}
+
+	payload := message.payload()
+	if len(envelope.Payload) > 0 && !bytes.Equal(envelope.Payload, []byte("null")) {
+		decoder = json.NewDecoder(bytes.NewReader(envelope.Payload))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(payload)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not parse %s payload: %v", envelope.Type, err)
+		}
+	}
+	err = payload.validate()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid %s payload: %v", envelope.Type, err)
+	}
+	return envelope, payload, nil
+}
+
+// Websocket channels, kept in each session's channel key.
+const (
+	wsTeamsChannel = "teams"
+	wsGameChannel  = "game"
+)
+
+// wsAuth requires the JWT the REST API uses on websocket upgrades. Browsers
+// cannot set headers on those, so the token comes as the subprotocols
+// "access_token, <token>". It is not accepted in the query string, where the
+// request log and proxies would record it. Like the JWT middleware, it leaves
+// the token in the context as "user".
+func (s *Server) wsAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		raw := wsToken(c.Request())
+		if len(raw) == 0 {
+			return c.JSON(http.StatusUnauthorized, "Missing access token")
+		}
+
+		token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+			if token.Method.Alg() != jwt.SigningMethodRS256.Alg() {
+				return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+			}
+			return s.key, nil
+		})
+		if err != nil || !token.Valid {
+			log.Warn("Rejected websocket token: ", err)
+			return c.JSON(http.StatusUnauthorized, "Invalid access token")
+		}
+
+		c.Set("user", token)
+		return next(c)
+	}
+}
+
+func wsToken(r *http.Request) string {
+	protocols := strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",")
+	for i := 0; i+1 < len(protocols); i++ {
+		if strings.TrimSpace(protocols[i]) == "access_token" {
+			return strings.TrimSpace(protocols[i+1])
+		}
+	}
+	return ""
+}
+
+// teamsSocketController opens a /ws/pbe/teams session.
+func (s *Server) teamsSocketController(c echo.Context) error {
+	s.hub.HandleRequestWithKeys(c.Response(), c.Request(), map[string]interface{}{
+		"channel":   wsTeamsChannel,
+		"userID":    currentUser(c),
+		"moderator": hasRole(c, RoleCounselor),
+	})
+	return nil
+}
+
+// gameSocketController opens a /ws/pbe/game session. Players' sessions
+// remember their team, so they only get their own team's messages.
+func (s *Server) gameSocketController(c echo.Context) error {
+	gameID := c.Param("gameID")
+	keys := map[string]interface{}{
+		"channel":   wsGameChannel,
+		"gameID":    gameID,
+		"userID":    currentUser(c),
+		"moderator": hasRole(c, RoleCounselor),
+		"teamID":    "",
+	}
+
+	if keys["moderator"] != true {
+		teamID, err := s.store.UserTeam(c.Request().Context(), gameID, currentUser(c))
+		if err != nil {
+			log.Error("Could not get team for: ", currentUser(c), " : ", err)
+			return c.JSON(http.StatusInternalServerError, "Could not get team for: "+currentUser(c)+" : "+err.Error())
+		}
+		keys["teamID"] = teamID
+	}
+
+	s.hub.HandleRequestWithKeys(c.Response(), c.Request(), keys)
+	return nil
+}
+
+// sessionGameID returns the game a /ws/pbe/game session is watching.
+func sessionGameID(session *melody.Session) string {
+	gameID, _ := session.Keys["gameID"].(string)
+	return gameID
+}
+
+// reaches reports whether the session should get the event.
+func (event *Event) reaches(session *melody.Session) bool {
+	if len(event.GameID) == 0 {
+		return session.Keys["channel"] == wsTeamsChannel
+	}
+	if session.Keys["channel"] != wsGameChannel || sessionGameID(session) != event.GameID {
+		return false
+	}
+
+	moderator := session.Keys["moderator"] == true
+	if event.ModeratorsOnly {
+		return moderator
+	}
+	if event.PlayersOnly {
+		return !moderator
+	}
+	if len(event.TeamID) > 0 {
+		return moderator || session.Keys["teamID"] == event.TeamID
+	}
+	return true
+}
+
+// handleMessage answers a client's websocket message. Messages that do not
+// follow the protocol get an error back and are otherwise ignored.
+func (s *Server) handleMessage(session *melody.Session, msg []byte) {
+	gameID := sessionGameID(session)
+	_, _, err := parseEnvelope(msg, gameID)
+	if err != nil {
+		log.Warn("Rejected websocket message: ", err)
+		s.sendSession(session, MsgError, gameID, &ErrorPayload{Message: err.Error()})
+		return
+	}
+
+	if session.Keys["channel"] == wsTeamsChannel {
+		s.sendGames(session)
+		return
+	}
+	s.sendGameState(session, gameID)
+}
+
+// sendGames sends the games to the session.
+func (s *Server) sendGames(session *melody.Session) {
+	games, err := s.store.Games(context.Background())
+	if err != nil {
+		log.Error("Could not get updated games: ", err)
+		return
+	}
+	s.sendSession(session, MsgGames, "", &GamesPayload{Games: games})
+}
+
+// sendGameState sends the game's current question, scoreboard and timer to
+// the session.
+func (s *Server) sendGameState(session *melody.Session, gameID string) {
+	ctx := context.Background()
+
+	question, err := s.store.CurrentQuestion(ctx, gameID)
+	if err != nil {
+		log.Error("Could not get the current question: ", err)
+		return
+	}
+	scoreboard, err := s.store.Scoreboard(ctx, gameID, session.Keys["moderator"] != true)
+	if err != nil {
+		log.Error("Could not get scoreboard: ", gameID, " : ", err)
+		return
+	}
+	timer, err := s.store.GameTimer(ctx, gameID)
+	if err != nil {
+		log.Error("Could not get game timer: ", gameID, " : ", err)
+		return
+	}
+
+	s.sendSession(session, MsgQuestionChanged, gameID, &QuestionChangedPayload{Question: question})
+	s.sendSession(session, MsgScoreboard, gameID, scoreboard)
+	s.sendSession(session, MsgTimerTick, gameID, timer)
+}
+
+// sendSession sends one message to one session.
+func (s *Server) sendSession(session *melody.Session, msgType, gameID string, payload wsPayload) {
+	msg, err := newEnvelope(msgType, gameID, payload)
+	if err != nil {
+		log.Error("Could not send ", msgType, ": ", err)
+		return
+	}
+	session.Write(msg)
+}
+
+// deliverEvent sends a published event to the websocket sessions it reaches.
+func (s *Server) deliverEvent(event *Event) {
+	msg, err := newEnvelope(event.Type, event.GameID, event.Payload)
+	if err != nil {
+		log.Error("Could not send ", event.Type, ": ", event.GameID, " : ", err)
+		return
+	}
+	s.hub.BroadcastFilter(msg, event.reaches)
+}